@@ -0,0 +1,148 @@
+package main
+
+// maxAnalysisDepth caps the tinue search, which grows very quickly with each extra move it looks ahead
+const maxAnalysisDepth = 2
+
+// Analysis sums up the road threats in a position, for players learning to spot them
+type Analysis struct {
+	Ply int `json:"ply"`
+	// ToMove is the color of the player whose turn it is
+	ToMove string `json:"toMove"`
+	// WhiteRoadWins and BlackRoadWins list every move, in PTN, that would finish a road for that player if it were
+	// their turn
+	WhiteRoadWins []string `json:"whiteRoadWins"`
+	BlackRoadWins []string `json:"blackRoadWins"`
+	// Tak means the player to move is facing a road threat: their opponent could finish a road on their next move
+	Tak bool `json:"tak"`
+	// Depth is how many of their own moves the tinue search gives each player
+	Depth int `json:"depth"`
+	// ForcedWin is a first move, in PTN, with which the player to move can force a road within Depth moves
+	ForcedWin string `json:"forcedWin,omitempty"`
+	// Tinue means that whatever the player to move does, their opponent can force a road within Depth moves
+	Tinue bool `json:"tinue"`
+}
+
+// Analyze looks for road threats and forced road wins in the current position, searching depth moves ahead
+func (tg *TakGame) Analyze(depth int) Analysis {
+	a := Analysis{
+		Ply:           tg.PlyCount(),
+		ToMove:        tg.toMove(),
+		WhiteRoadWins: tg.RoadWins(White),
+		BlackRoadWins: tg.RoadWins(Black),
+		Depth:         depth,
+	}
+	a.Tak = len(a.WhiteRoadWins) > 0
+	if a.ToMove == White {
+		a.Tak = len(a.BlackRoadWins) > 0
+	}
+	if win := tg.ForcedRoadWin(depth); win != nil {
+		a.ForcedWin, _ = ActionPTN(win)
+	}
+	a.Tinue = tg.InTinue(depth)
+	return a
+}
+
+// toMove is the color of the player whose turn it is
+func (tg *TakGame) toMove() string {
+	if tg.IsBlackTurn {
+		return Black
+	}
+	return White
+}
+
+// movesPTN writes out a list of moves in PTN
+func movesPTN(moves []interface{}) []string {
+	ptn := []string{}
+	for _, move := range moves {
+		p, _ := ActionPTN(move)
+		ptn = append(ptn, p)
+	}
+	return ptn
+}
+
+// playCopy plays a move on a copy of the game, leaving the game itself as it was
+func (tg *TakGame) playCopy(move interface{}) (*TakGame, error) {
+	next := tg.perftCopy()
+	if err := next.ApplyAction(move); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// roadWinningMoves finds the moves that would finish a road for the given color if it were their turn, stopping at
+// the first one if that's all that's wanted. A move that finishes roads for both players counts, since the rules
+// give those to the player who moved.
+func (tg *TakGame) roadWinningMoves(color string, firstOnly bool) []interface{} {
+	wins := []interface{}{}
+	position := tg.perftCopy()
+	position.IsBlackTurn = color == Black
+	for _, move := range position.LegalMoves() {
+		next, err := position.playCopy(move)
+		if err != nil || next.RoadWinner() != color {
+			continue
+		}
+		wins = append(wins, move)
+		if firstOnly {
+			break
+		}
+	}
+	return wins
+}
+
+// RoadWins lists every move, in PTN, that would finish a road for the given color if it were their turn
+func (tg *TakGame) RoadWins(color string) []string {
+	return movesPTN(tg.roadWinningMoves(color, false))
+}
+
+// InTak is true when the player to move is facing a road threat: their opponent could finish a road next move
+func (tg *TakGame) InTak() bool {
+	return len(tg.roadWinningMoves(oppositeColor(tg.toMove()), true)) > 0
+}
+
+// ForcedRoadWin looks for a move with which the player to move can force a road within depth of their own moves,
+// and returns nil if there isn't one. As in tinue puzzles, every move before the road itself has to be tak, a threat
+// the opponent has to answer; looking at quiet moves as well would make anything past a couple of moves far too slow.
+func (tg *TakGame) ForcedRoadWin(depth int) interface{} {
+	if wins := tg.roadWinningMoves(tg.toMove(), true); len(wins) > 0 {
+		return wins[0]
+	}
+	if depth <= 1 {
+		return nil
+	}
+	for _, move := range tg.LegalMoves() {
+		next, err := tg.playCopy(move)
+		if err != nil || next.GameOver || !next.InTak() {
+			continue
+		}
+		if next.InTinue(depth - 1) {
+			return move
+		}
+	}
+	return nil
+}
+
+// InTinue is true when the player to move can't stop their opponent forcing a road within depth of the
+// opponent's moves, whatever they do
+func (tg *TakGame) InTinue(depth int) bool {
+	defender := tg.toMove()
+	if tg.GameOver || len(tg.roadWinningMoves(defender, true)) > 0 {
+		return false
+	}
+	for _, move := range tg.LegalMoves() {
+		next, err := tg.playCopy(move)
+		if err != nil {
+			continue
+		}
+		if next.GameOver {
+			// moving a stack can finish the opponent's road for them, which is no escape; anything else is
+			if next.GameWinner == oppositeColor(defender) {
+				continue
+			}
+			return false
+		}
+		if next.ForcedRoadWin(depth) == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -100,3 +100,16 @@ func (tg *TakGame) PlayersTurn(p *TakPlayer) bool {
 		return false
 	}
 }
+
+// PlayerColor tells which side a given username is playing in the game, or "" if they're not seated
+func (tg *TakGame) PlayerColor(username string) string {
+	switch {
+	case username == "":
+		return ""
+	case username == tg.WhitePlayer:
+		return White
+	case username == tg.BlackPlayer:
+		return Black
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bot is a computer player: given a game, it picks a move for whoever's turn it is
+type Bot interface {
+	ChooseMove(tg *TakGame) (interface{}, error)
+}
+
+// botPrefix marks a seat as taken by a bot rather than a registered player: the bot called "minimax" sits at a
+// game as "bot:minimax". Registration won't hand out names that start with it.
+const botPrefix = "bot:"
+
+// Bots are the computer opponents players can seat at their games, by name. They run from easy to hard.
+var Bots = map[string]Bot{
+	"minimax-easy": &MinimaxBot{MaxDepth: 1, Budget: time.Second},
+	"mcts-easy":    &MCTSBot{Playouts: 100, Budget: time.Second},
+	"mcts":         &MCTSBot{Playouts: 1000, Budget: 2 * time.Second, Biased: true, Book: true},
+	"minimax":      &MinimaxBot{MaxDepth: 4, Budget: 2 * time.Second, Book: true},
+}
+
+// BotPlayerName is the name a bot sits at a game under
+func BotPlayerName(name string) string {
+	return botPrefix + name
+}
+
+// BotFor finds the bot sitting at a game under the given player name, or nil for a human player
+func BotFor(username string) Bot {
+	if !strings.HasPrefix(username, botPrefix) {
+		return nil
+	}
+	return Bots[strings.TrimPrefix(username, botPrefix)]
+}
+
+// botToMove finds the bot whose turn it is in a game that's under way, if it's a bot's turn at all
+func (tg *TakGame) botToMove() (string, Bot) {
+	if tg.GameOver || tg.WhitePlayer == "" || tg.BlackPlayer == "" {
+		return "", nil
+	}
+	player := tg.WhitePlayer
+	if tg.IsBlackTurn {
+		player = tg.BlackPlayer
+	}
+	return player, BotFor(player)
+}
+
+// opponentOf is the player sitting across the board from a player in the game
+func (tg *TakGame) opponentOf(username string) string {
+	if username == tg.WhitePlayer {
+		return tg.BlackPlayer
+	}
+	return tg.WhitePlayer
+}
+
+// botPending says whether a bot at the game has something to do: a move to make, or a request to answer
+func (tg *TakGame) botPending() bool {
+	if _, bot := tg.botToMove(); bot != nil {
+		return true
+	}
+	if tg.PendingTakeback != nil && BotFor(tg.opponentOf(tg.PendingTakeback.Player)) != nil {
+		return true
+	}
+	return tg.DrawOffer != "" && BotFor(tg.opponentOf(tg.DrawOffer)) != nil
+}
+
+// answerForBots has a bot answer whatever its opponent has asked of it. A bot always grants a takeback, since it
+// has nothing to lose by one, and always plays on rather than agree a draw.
+func (tg *TakGame) answerForBots() error {
+	if request := tg.PendingTakeback; request != nil {
+		if bot := tg.opponentOf(request.Player); BotFor(bot) != nil {
+			if err := tg.AnswerTakeback(bot, true); err != nil {
+				return fmt.Errorf("%v couldn't answer a takeback: %v", bot, err)
+			}
+		}
+	}
+	if tg.DrawOffer != "" {
+		if bot := tg.opponentOf(tg.DrawOffer); BotFor(bot) != nil {
+			if err := tg.AnswerDrawOffer(bot, false); err != nil {
+				return fmt.Errorf("%v couldn't answer a draw offer: %v", bot, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PlayBotMoves plays for a bot whenever it's the bot's turn, so that a player playing a bot gets its reply straight
+// back, and a bot with the first move makes it as soon as both seats are filled. Takebacks and draw offers made to
+// a bot get answered first.
+func (tg *TakGame) PlayBotMoves() error {
+	if err := tg.answerForBots(); err != nil {
+		return err
+	}
+	for !tg.IsGameOver() {
+		player, bot := tg.botToMove()
+		if bot == nil {
+			return nil
+		}
+		move, err := bot.ChooseMove(tg)
+		if err != nil {
+			return fmt.Errorf("%v couldn't find a move: %v", player, err)
+		}
+		if err := tg.ApplyAction(move); err != nil {
+			return fmt.Errorf("%v chose a bad move: %v", player, err)
+		}
+		if tg.StartTime.IsZero() {
+			tg.StartTime = time.Now()
+		}
+		tg.PunchClock()
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// TimeControl says how long players get to make their moves. A game either has a clock, with an Initial time plus
+// an optional Increment or Delay on every move, or is a correspondence game with DaysPerMove to make each move in.
+type TimeControl struct {
+	// Initial is each player's starting time, in seconds
+	Initial int `json:"initial,omitempty"`
+	// Increment is added to a player's time, in seconds, after each of their moves
+	Increment int `json:"increment,omitempty"`
+	// Delay is how long, in seconds, a player can think at the start of each turn before their clock starts running
+	Delay int `json:"delay,omitempty"`
+	// DaysPerMove is how long a correspondence player has to make each move, however quickly they made the last one
+	DaysPerMove int `json:"daysPerMove,omitempty"`
+}
+
+// Validate checks that a time control makes sense
+func (tc TimeControl) Validate() error {
+	switch {
+	case tc.Initial < 0 || tc.Increment < 0 || tc.Delay < 0 || tc.DaysPerMove < 0:
+		return errors.New("times can't be negative")
+	case tc.DaysPerMove > 0 && (tc.Initial > 0 || tc.Increment > 0 || tc.Delay > 0):
+		return errors.New("a correspondence game can't have an initial time, increment or delay as well")
+	case tc.DaysPerMove == 0 && tc.Initial == 0:
+		return errors.New("needs either an initial time or a number of days per move")
+	case tc.Increment > 0 && tc.Delay > 0:
+		return errors.New("can have an increment or a delay, but not both")
+	}
+	return nil
+}
+
+// turnTime is the time a player starts with, and for correspondence games the time they get back after every move
+func (tc TimeControl) turnTime() time.Duration {
+	if tc.DaysPerMove > 0 {
+		return time.Duration(tc.DaysPerMove) * 24 * time.Hour
+	}
+	return time.Duration(tc.Initial) * time.Second
+}
+
+// GameClock keeps track of each player's time in a timed game. Times are in milliseconds.
+type GameClock struct {
+	// WhiteBank and BlackBank are the time each player had left when the current turn started
+	WhiteBank int64 `json:"whiteBank"`
+	BlackBank int64 `json:"blackBank"`
+	// TurnStarted is when the player to move started thinking. It stays zero until the first move is played.
+	TurnStarted time.Time `json:"turnStarted"`
+	// WhiteRemaining and BlackRemaining are each player's time left as of CheckedAt, counting the turn in progress
+	WhiteRemaining int64     `json:"whiteRemaining"`
+	BlackRemaining int64     `json:"blackRemaining"`
+	CheckedAt      time.Time `json:"checkedAt"`
+}
+
+// clockNow is what the clocks read the time from, so tests can move time along without waiting for it
+var clockNow = time.Now
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// StartClock gives both players their starting time, for a game with a TimeControl
+func (tg *TakGame) StartClock() {
+	if tg.TimeControl == nil {
+		return
+	}
+	start := millis(tg.TimeControl.turnTime())
+	tg.Clock = &GameClock{WhiteBank: start, BlackBank: start, WhiteRemaining: start, BlackRemaining: start, CheckedAt: clockNow()}
+}
+
+// timeUsed is how much of the player to move's time their turn has taken so far, not counting any delay
+func (tg *TakGame) timeUsed(at time.Time) int64 {
+	if tg.Clock.TurnStarted.IsZero() {
+		return 0
+	}
+	used := at.Sub(tg.Clock.TurnStarted) - time.Duration(tg.TimeControl.Delay)*time.Second
+	if used < 0 {
+		return 0
+	}
+	return millis(used)
+}
+
+// UpdateClock works out how much time each player has left right now, and ends the game if the player to move has
+// run out. Nothing runs between moves to notice a flag falling, so it's caught whenever the game is next looked at,
+// and UpdateClock says whether it was caught this time.
+func (tg *TakGame) UpdateClock() bool {
+	// a finished game's clock stays stopped where it was
+	if tg.Clock == nil || tg.TimeControl == nil || tg.GameOver {
+		return false
+	}
+	c := tg.Clock
+	c.CheckedAt = clockNow()
+	c.WhiteRemaining, c.BlackRemaining = c.WhiteBank, c.BlackBank
+
+	mover, player, remaining := White, tg.WhitePlayer, &c.WhiteRemaining
+	if tg.IsBlackTurn {
+		mover, player, remaining = Black, tg.BlackPlayer, &c.BlackRemaining
+	}
+	*remaining -= tg.timeUsed(c.CheckedAt)
+	if *remaining > 0 {
+		return false
+	}
+
+	*remaining = 0
+	tg.DrawOffer = ""
+	tg.PendingTakeback = nil
+	tg.recordResult(oppositeColor(mover), byTime)
+	tg.recordEvent(GameEvent{Type: FlagFell, Player: player})
+	tg.IsGameOver()
+	return true
+}
+
+// PunchClock ends the turn of the player who's just moved: it charges them for the time they took, adds any
+// increment, and starts their opponent's turn. The first move of the game starts the clock running.
+func (tg *TakGame) PunchClock() {
+	if tg.Clock == nil || tg.TimeControl == nil {
+		return
+	}
+	c := tg.Clock
+	at := clockNow()
+
+	// the turn indicator has already flipped, so the player who moved is the one whose turn it isn't
+	bank := &c.WhiteBank
+	if !tg.IsBlackTurn {
+		bank = &c.BlackBank
+	}
+	if tg.TimeControl.DaysPerMove > 0 {
+		*bank = millis(tg.TimeControl.turnTime())
+	} else {
+		*bank += millis(time.Duration(tg.TimeControl.Increment)*time.Second) - tg.timeUsed(at)
+	}
+
+	c.TurnStarted = at
+	c.WhiteRemaining, c.BlackRemaining = c.WhiteBank, c.BlackBank
+	c.CheckedAt = at
+}
+
+// restartTurn starts the current turn afresh once a takeback has changed the position under the clock: the player
+// now to move gets their banked time back, and nobody is charged for the time since the last move. A game taken back
+// to its first move waits for that move to start the clock again.
+func (tg *TakGame) restartTurn() {
+	if tg.Clock == nil || tg.TimeControl == nil {
+		return
+	}
+	c := tg.Clock
+	at := clockNow()
+	c.TurnStarted = at
+	if tg.PlyCount() == 0 {
+		c.TurnStarted = time.Time{}
+	}
+	c.WhiteRemaining, c.BlackRemaining = c.WhiteBank, c.BlackBank
+	c.CheckedAt = at
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	// sql backend for this deployment
 	_ "github.com/mattn/go-sqlite3"
@@ -22,9 +23,20 @@ type DBenv struct {
 type Datastore interface {
 	StoreTakGame(tg *TakGame) error
 	RetrieveTakGame(id uuid.UUID) (*TakGame, error)
+	FinishedGames() ([]*TakGame, error)
+	ListGames(f GameFilter, viewer string) ([]*TakGame, int, error)
 	StorePlayer(p *TakPlayer) error
 	RetrievePlayer(name string) (*TakPlayer, error)
 	PlayerExists(n string) bool
+	StorePuzzle(p *Puzzle) error
+	RetrievePuzzle(id uuid.UUID) (*Puzzle, error)
+	Puzzles() ([]*Puzzle, error)
+	StorePuzzleAttempt(a *PuzzleAttempt) error
+	PuzzleAttempts(username string) ([]*PuzzleAttempt, error)
+	StoreSeek(s *Seek) error
+	RetrieveSeek(id uuid.UUID) (*Seek, error)
+	DeleteSeek(id uuid.UUID) error
+	Seeks() ([]*Seek, error)
 }
 
 // DB is simply a self-contained struct that carries a SQL-capable DB and the methods necessary to satisfy the Datastore interface requirements
@@ -48,16 +60,88 @@ func InitSQLiteDB(dataSourceName string) (*DB, error) {
 	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS games (guid BLOB(16) PRIMARY KEY UNIQUE, isOver BOOL, isPublic BOOL, hasStarted BOOL, gameBlob VARCHAR)"); err != nil {
 		return nil, err
 	}
+	if err = (&DB{db}).addLobbyColumns(); err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS puzzles (guid BLOB(16) PRIMARY KEY UNIQUE, puzzleBlob VARCHAR)"); err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS puzzleAttempts (puzzle BLOB(16), username VARCHAR, solved BOOL, attemptBlob VARCHAR)"); err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS seeks (guid BLOB(16) PRIMARY KEY UNIQUE, seekBlob VARCHAR)"); err != nil {
+		return nil, err
+	}
 	return &DB{db}, nil
 }
 
-// StoreTakGame puts a given game into the database
+// lobbyColumns are the columns the lobby searches and sorts games by, on top of the ones the games table started with
+var lobbyColumns = []string{"size INTEGER", "created DATETIME", "lastMove DATETIME", "whitePlayer VARCHAR", "blackPlayer VARCHAR", "gameOwner VARCHAR"}
+
+// addLobbyColumns adds any lobbyColumns the games table doesn't have yet, and fills them in for the games already
+// stored in it
+func (db *DB) addLobbyColumns() error {
+	rows, err := db.Query("PRAGMA table_info(games)")
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	added := false
+	for _, column := range lobbyColumns {
+		if existing[strings.Fields(column)[0]] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE games ADD COLUMN " + column); err != nil {
+			return err
+		}
+		added = true
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS gamesByCreated ON games (created)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS gamesByLastMove ON games (lastMove)"); err != nil {
+		return err
+	}
+	if !added {
+		return nil
+	}
+
+	// storing each game again fills in the new columns from its blob
+	games, err := db.allGames()
+	if err != nil {
+		return err
+	}
+	for _, tg := range games {
+		if err := db.StoreTakGame(tg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreTakGame puts a given game into the database, along with the columns the lobby searches on
 func (db *DB) StoreTakGame(tg *TakGame) error {
 	textGame, _ := json.Marshal(tg)
+	// times go in as UTC so that they sort in order
+	created, lastMove := tg.Created.UTC(), tg.LastMoveTime().UTC()
 	// this clever little two step handles INSERT OR UPDATE in sqlite3 so that one can store an existing game and/or have it update an existing row in the db
 	// http://stackoverflow.com/questions/15277373/sqlite-upsert-update-or-insert
-	db.Exec("UPDATE games SET guid=?, isOver=?, isPublic=?, hasStarted=?, gameBlob=? WHERE guid=?", tg.GameID, tg.GameOver, tg.IsPublic, tg.HasStarted, textGame, tg.GameID)
-	_, err := db.Exec("INSERT INTO games(guid, isOver, isPublic, hasStarted, gameBlob) SELECT ?, ?, ?, ?, ? WHERE (SELECT CHANGES() = 0)", tg.GameID, tg.GameOver, tg.IsPublic, tg.HasStarted, textGame)
+	db.Exec("UPDATE games SET guid=?, isOver=?, isPublic=?, hasStarted=?, size=?, created=?, lastMove=?, whitePlayer=?, blackPlayer=?, gameOwner=?, gameBlob=? WHERE guid=?", tg.GameID, tg.GameOver, tg.IsPublic, tg.HasStarted, tg.Size, created, lastMove, tg.WhitePlayer, tg.BlackPlayer, tg.GameOwner, textGame, tg.GameID)
+	_, err := db.Exec("INSERT INTO games(guid, isOver, isPublic, hasStarted, size, created, lastMove, whitePlayer, blackPlayer, gameOwner, gameBlob) SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? WHERE (SELECT CHANGES() = 0)", tg.GameID, tg.GameOver, tg.IsPublic, tg.HasStarted, tg.Size, created, lastMove, tg.WhitePlayer, tg.BlackPlayer, tg.GameOwner, textGame)
 
 	if err != nil {
 		return err
@@ -83,6 +167,87 @@ func (db *DB) RetrieveTakGame(id uuid.UUID) (*TakGame, error) {
 	return &retrievedGame, nil
 }
 
+// FinishedGames gets every game that's over from the db
+func (db *DB) FinishedGames() ([]*TakGame, error) {
+	return db.queryGames("SELECT guid, gameBlob FROM games WHERE isOver = ?", true)
+}
+
+// allGames gets every game from the db
+func (db *DB) allGames() ([]*TakGame, error) {
+	return db.queryGames("SELECT guid, gameBlob FROM games")
+}
+
+// ListGames gets one page of the games matching a lobby filter that the viewer is allowed to see, along with the
+// number of matching games on every page. Private games only show up for their owner and players.
+func (db *DB) ListGames(f GameFilter, viewer string) ([]*TakGame, int, error) {
+	where := []string{"(isPublic = ? OR whitePlayer = ? OR blackPlayer = ? OR gameOwner = ?)"}
+	args := []interface{}{true, viewer, viewer, viewer}
+	switch f.State {
+	case GameWaiting:
+		where = append(where, "isOver = ?", "(whitePlayer = '' OR blackPlayer = '')")
+		args = append(args, false)
+	case GameInProgress:
+		where = append(where, "isOver = ?", "whitePlayer != ''", "blackPlayer != ''")
+		args = append(args, false)
+	case GameFinished:
+		where = append(where, "isOver = ?")
+		args = append(args, true)
+	}
+	if f.Size != 0 {
+		where = append(where, "size = ?")
+		args = append(args, f.Size)
+	}
+	if f.PublicOnly {
+		where = append(where, "isPublic = ?")
+		args = append(args, true)
+	}
+	if f.Player != "" {
+		where = append(where, "(whitePlayer = ? OR blackPlayer = ? OR gameOwner = ?)")
+		args = append(args, f.Player, f.Player, f.Player)
+	}
+	whereClause := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM games"+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	order := "created"
+	if f.Sort == SortByLastMove {
+		order = "lastMove"
+	}
+	games, err := db.queryGames("SELECT guid, gameBlob FROM games"+whereClause+" ORDER BY "+order+" DESC, rowid LIMIT ? OFFSET ?", append(args, f.PerPage, (f.Page-1)*f.PerPage)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return games, total, nil
+}
+
+// queryGames runs a query that selects games' guids and gameBlobs, and decodes the games. A game that won't decode
+// is logged and left out, rather than spoiling every other game the query finds.
+func (db *DB) queryGames(query string, args ...interface{}) ([]*TakGame, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []*TakGame{}
+	for rows.Next() {
+		var guid, gameBlob string
+		if err := rows.Scan(&guid, &gameBlob); err != nil {
+			return nil, err
+		}
+		retrievedGame := TakGame{}
+		if unmarshalError := json.Unmarshal([]byte(gameBlob), &retrievedGame); unmarshalError != nil {
+			log.Printf("skipping game %v: problem decoding JSON: %v", guid, unmarshalError)
+			continue
+		}
+		games = append(games, &retrievedGame)
+	}
+	return games, rows.Err()
+}
+
 // StorePlayer puts a given player into the database
 func (db *DB) StorePlayer(p *TakPlayer) error {
 	pg, _ := json.Marshal(p.PlayedGames)
@@ -137,3 +302,140 @@ func (db *DB) PlayerExists(n string) bool {
 	return true
 
 }
+
+// StorePuzzle puts a given puzzle into the database, replacing any puzzle already stored for the same position
+func (db *DB) StorePuzzle(p *Puzzle) error {
+	textPuzzle, _ := json.Marshal(p)
+	_, err := db.Exec("INSERT OR REPLACE INTO puzzles(guid, puzzleBlob) VALUES (?, ?)", p.PuzzleID, textPuzzle)
+	return err
+}
+
+// RetrievePuzzle gets a puzzle from the db
+func (db *DB) RetrievePuzzle(id uuid.UUID) (*Puzzle, error) {
+	var puzzleBlob string
+	queryErr := db.QueryRow("SELECT puzzleBlob FROM puzzles WHERE guid = ?", id).Scan(&puzzleBlob)
+	switch {
+	case queryErr == sql.ErrNoRows:
+		return nil, errors.New("No such puzzle found")
+	case queryErr != nil:
+		return nil, queryErr
+	}
+	retrievedPuzzle := Puzzle{}
+	if unmarshalError := json.Unmarshal([]byte(puzzleBlob), &retrievedPuzzle); unmarshalError != nil {
+		return nil, errors.New("Problem decoding JSON")
+	}
+	return &retrievedPuzzle, nil
+}
+
+// Puzzles gets every puzzle from the db
+func (db *DB) Puzzles() ([]*Puzzle, error) {
+	rows, err := db.Query("SELECT puzzleBlob FROM puzzles")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	puzzles := []*Puzzle{}
+	for rows.Next() {
+		var puzzleBlob string
+		if err := rows.Scan(&puzzleBlob); err != nil {
+			return nil, err
+		}
+		retrievedPuzzle := Puzzle{}
+		if unmarshalError := json.Unmarshal([]byte(puzzleBlob), &retrievedPuzzle); unmarshalError != nil {
+			return nil, errors.New("Problem decoding JSON")
+		}
+		puzzles = append(puzzles, &retrievedPuzzle)
+	}
+	return puzzles, rows.Err()
+}
+
+// StorePuzzleAttempt records a player's attempt at a puzzle
+func (db *DB) StorePuzzleAttempt(a *PuzzleAttempt) error {
+	textAttempt, _ := json.Marshal(a)
+	_, err := db.Exec("INSERT INTO puzzleAttempts(puzzle, username, solved, attemptBlob) VALUES (?, ?, ?, ?)", a.PuzzleID, a.Username, a.Solved, textAttempt)
+	return err
+}
+
+// PuzzleAttempts gets every attempt a player has made at the puzzles, oldest first
+func (db *DB) PuzzleAttempts(username string) ([]*PuzzleAttempt, error) {
+	rows, err := db.Query("SELECT attemptBlob FROM puzzleAttempts WHERE username = ? ORDER BY rowid", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*PuzzleAttempt{}
+	for rows.Next() {
+		var attemptBlob string
+		if err := rows.Scan(&attemptBlob); err != nil {
+			return nil, err
+		}
+		attempt := PuzzleAttempt{}
+		if unmarshalError := json.Unmarshal([]byte(attemptBlob), &attempt); unmarshalError != nil {
+			return nil, errors.New("Problem decoding JSON")
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// StoreSeek puts a given seek into the database
+func (db *DB) StoreSeek(s *Seek) error {
+	textSeek, _ := json.Marshal(s)
+	_, err := db.Exec("INSERT OR REPLACE INTO seeks(guid, seekBlob) VALUES (?, ?)", s.SeekID, textSeek)
+	return err
+}
+
+// RetrieveSeek gets a seek from the db
+func (db *DB) RetrieveSeek(id uuid.UUID) (*Seek, error) {
+	var seekBlob string
+	queryErr := db.QueryRow("SELECT seekBlob FROM seeks WHERE guid = ?", id).Scan(&seekBlob)
+	switch {
+	case queryErr == sql.ErrNoRows:
+		return nil, errors.New("No such seek found")
+	case queryErr != nil:
+		return nil, queryErr
+	}
+	retrievedSeek := Seek{}
+	if unmarshalError := json.Unmarshal([]byte(seekBlob), &retrievedSeek); unmarshalError != nil {
+		return nil, errors.New("Problem decoding JSON")
+	}
+	return &retrievedSeek, nil
+}
+
+// DeleteSeek takes a seek out of the db once it's been accepted, cancelled or has expired. Only one caller can
+// delete any given seek, so whoever does gets to act on it.
+func (db *DB) DeleteSeek(id uuid.UUID) error {
+	result, err := db.Exec("DELETE FROM seeks WHERE guid = ?", id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return errors.New("No such seek found")
+	}
+	return nil
+}
+
+// Seeks gets every seek from the db, oldest first
+func (db *DB) Seeks() ([]*Seek, error) {
+	rows, err := db.Query("SELECT seekBlob FROM seeks ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seeks := []*Seek{}
+	for rows.Next() {
+		var seekBlob string
+		if err := rows.Scan(&seekBlob); err != nil {
+			return nil, err
+		}
+		retrievedSeek := Seek{}
+		if unmarshalError := json.Unmarshal([]byte(seekBlob), &retrievedSeek); unmarshalError != nil {
+			return nil, errors.New("Problem decoding JSON")
+		}
+		seeks = append(seeks, &retrievedSeek)
+	}
+	return seeks, rows.Err()
+}
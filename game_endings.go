@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Resign ends the game in the opponent's favor. A player can resign whoever's turn it is.
+func (tg *TakGame) Resign(username string) error {
+	color := tg.PlayerColor(username)
+	switch {
+	case color == "":
+		return errors.New("only the players in a game can resign it")
+	case tg.GameOver:
+		return errors.New("game is already over")
+	}
+	tg.DrawOffer = ""
+	tg.PendingTakeback = nil
+	tg.recordResult(oppositeColor(color), byResignation)
+	tg.recordEvent(GameEvent{Type: Resigned, Player: username})
+	tg.IsGameOver()
+	return nil
+}
+
+// OfferDraw offers the opponent a draw, which stands until they answer it or make a move of their own
+func (tg *TakGame) OfferDraw(username string) error {
+	switch {
+	case tg.PlayerColor(username) == "":
+		return errors.New("only the players in a game can offer a draw")
+	case tg.GameOver:
+		return errors.New("game is already over")
+	case tg.DrawOffer != "" && tg.DrawOffer != username:
+		return fmt.Errorf("%v has already offered a draw: accept or decline it", tg.DrawOffer)
+	}
+	tg.DrawOffer = username
+	tg.recordEvent(GameEvent{Type: DrawOffered, Player: username})
+	return nil
+}
+
+// AnswerDrawOffer accepts or declines the draw on offer. Only the opponent of whoever offered it can answer.
+func (tg *TakGame) AnswerDrawOffer(username string, accept bool) error {
+	switch {
+	case tg.DrawOffer == "":
+		return errors.New("no draw has been offered")
+	case tg.PlayerColor(username) == "" || username == tg.DrawOffer:
+		return fmt.Errorf("only %v's opponent can answer their draw offer", tg.DrawOffer)
+	}
+	tg.DrawOffer = ""
+	if !accept {
+		tg.recordEvent(GameEvent{Type: DrawDeclined, Player: username})
+		return nil
+	}
+	tg.PendingTakeback = nil
+	tg.recordResult("", byAgreement)
+	tg.recordEvent(GameEvent{Type: DrawAccepted, Player: username})
+	tg.IsGameOver()
+	return nil
+}
+
+// lapseDrawOffer turns down a draw offer once the player it was made to moves instead of answering it
+func (tg *TakGame) lapseDrawOffer(mover string) {
+	if tg.DrawOffer != "" && tg.DrawOffer != mover {
+		tg.recordEvent(GameEvent{Type: DrawLapsed, Player: tg.DrawOffer})
+		tg.DrawOffer = ""
+	}
+}
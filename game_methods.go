@@ -9,6 +9,16 @@ import (
 	"time"
 )
 
+// the rules checks below run for every candidate move the move generator considers, so compile these just the once
+var (
+	blackRegexp       = regexp.MustCompile("^(?i)black$")
+	whiteRegexp       = regexp.MustCompile("^(?i)white$")
+	colorRegexp       = regexp.MustCompile("^((?i)black|white)$")
+	orientationRegexp = regexp.MustCompile("^((?i)flat|wall|capstone)")
+	coordsRegexp      = regexp.MustCompile("^([a-h])([1-8])$")
+	directionRegexp   = regexp.MustCompile("^[+<>-]$")
+)
+
 // PlacePiece should put a Piece at a valid board position and return the updated board
 func (tg *TakGame) PlacePiece(p Placement) error {
 
@@ -33,14 +43,11 @@ func (tg *TakGame) PlacePiece(p Placement) error {
 	// Place That Piece! (top of the piece stacks is at position 0)
 	square.Pieces = append([]Piece{p.Piece}, square.Pieces...)
 
-	// Record the placement!
-	tg.TurnHistory = append(tg.TurnHistory, p)
-	// flip the turn indicator
+	// flip the turn indicator, and record the placement!
 	tg.IsBlackTurn = (tg.IsBlackTurn == false)
+	tg.recordMove(p)
 
-	if tg.IsGameOver() {
-		tg.WhoWins()
-	}
+	tg.evaluateGameEnd()
 	return nil
 }
 
@@ -87,10 +94,10 @@ func (tg *TakGame) MoveStack(m Movement) error {
 			x--
 		case "+":
 			nextSquare = &tg.GameBoard[x][y+1]
-			y--
+			y++
 		case "-":
 			nextSquare = &tg.GameBoard[x][y-1]
-			y++
+			y--
 		default:
 			return fmt.Errorf("can't determine movement direction '%v'", m.Direction)
 		}
@@ -107,13 +114,64 @@ func (tg *TakGame) MoveStack(m Movement) error {
 
 	}
 
-	// record the move in the game's turn history, and flip the turn indicator
-	tg.TurnHistory = append(tg.TurnHistory, m)
+	// flip the turn indicator, and record the move in the game's turn history
 	tg.IsBlackTurn = (tg.IsBlackTurn == false)
+	tg.recordMove(m)
 
+	tg.evaluateGameEnd()
 	return nil
 }
 
+// evaluateGameEnd runs after every placement and movement, and records the result if that turn finished the game
+func (tg *TakGame) evaluateGameEnd() {
+	tg.UpdateReserves()
+	if tg.IsGameOver() {
+		tg.WhoWins()
+		return
+	}
+	if tg.Rules.Repetition == RepetitionDraws && tg.RepetitionCount() >= repetitionLimit {
+		tg.recordResult("", byRepetition)
+		tg.IsGameOver()
+	}
+}
+
+// Copy makes a deep copy of the game, so that moves can be tried out without touching the original
+func (tg *TakGame) Copy() *TakGame {
+	c := *tg
+	c.GameBoard = make(GameBoard, len(tg.GameBoard))
+	for x := range tg.GameBoard {
+		c.GameBoard[x] = make([]Stack, len(tg.GameBoard[x]))
+		for y := range tg.GameBoard[x] {
+			if tg.GameBoard[x][y].Pieces != nil {
+				c.GameBoard[x][y].Pieces = append([]Piece{}, tg.GameBoard[x][y].Pieces...)
+			}
+		}
+	}
+	c.TurnHistory = append([]MoveRecord(nil), tg.TurnHistory...)
+	c.WinningPath = append(WinningPath(nil), tg.WinningPath...)
+	return &c
+}
+
+// PlyCount is the number of plies (half-moves) played in the game so far, including any played before a TPS starting position
+func (tg *TakGame) PlyCount() int {
+	return tg.InitialPly + len(tg.TurnHistory)
+}
+
+// blackMovedFirst works backwards from whose turn it is to figure out which player made the game's first ply
+func (tg *TakGame) blackMovedFirst() bool {
+	return tg.IsBlackTurn == (tg.PlyCount()%2 == 0)
+}
+
+// MoveNumber is the current full move number, which (as in PTN and TPS) ticks over once both players have moved
+func (tg *TakGame) MoveNumber() int {
+	ply := tg.PlyCount()
+	if tg.blackMovedFirst() {
+		// count black's opening ply as the second half of move 1
+		ply++
+	}
+	return ply/2 + 1
+}
+
 // FindMovingPieces determines which pieces will move with a given Movement
 func (tg *TakGame) FindMovingPieces(m Movement) []Piece {
 	// I've already validated the move above explicitly; assume no error
@@ -142,26 +200,28 @@ func (tg *TakGame) ValidatePlacement(p Placement) error {
 	squareIsEmpty, emptyErr := tg.SquareIsEmpty(p.Coords)
 	tooManyCapstones := tg.TooManyCapstones(p)
 	hitPieceLimit, pieceErr := tg.HitPieceLimit()
-	rBlack := regexp.MustCompile("^(?i)black$")
-	rWhite := regexp.MustCompile("^(?i)white$")
 	switch {
 	case emptyErr != nil:
 		return fmt.Errorf("Problem checking square %v: %v", p.Coords, emptyErr)
-	case tg.IsBlackTurn && rWhite.MatchString(p.Piece.Color) && len(tg.TurnHistory) > 2:
+	case tg.IsBlackTurn && whiteRegexp.MatchString(p.Piece.Color) && !tg.InOpeningSwap():
 		return errors.New("Cannot place white piece on black turn")
-	case tg.IsBlackTurn == false && rBlack.MatchString(p.Piece.Color) && len(tg.TurnHistory) > 2:
+	case tg.IsBlackTurn == false && blackRegexp.MatchString(p.Piece.Color) && !tg.InOpeningSwap():
 		return errors.New("Cannot place black piece on white turn")
-	case ((tg.IsBlackTurn && rBlack.MatchString(p.Piece.Color)) || (tg.IsBlackTurn == false && rWhite.MatchString(p.Piece.Color))) && len(tg.TurnHistory) < 2:
+	case ((tg.IsBlackTurn && blackRegexp.MatchString(p.Piece.Color)) || (tg.IsBlackTurn == false && whiteRegexp.MatchString(p.Piece.Color))) && tg.InOpeningSwap():
 		// the very first two placements must be of the opposite color than usual
 		return errors.New("first two placements must be of the opponent's color")
+	case p.Piece.Orientation != Flat && tg.InOpeningSwap():
+		return errors.New("first two placements must be flat pieces")
 	case squareIsEmpty != true:
 		return fmt.Errorf("Cannot place piece on occupied square %v", p.Coords)
-	case len(tg.GameBoard) < 5 && p.Piece.Orientation == Capstone:
+	case len(tg.GameBoard) < 5 && p.Piece.Orientation == Capstone && tg.CapstoneLimit() == 0:
 		return errors.New("no capstones allowed in games smaller than 5x5")
 	case p.Piece.Orientation == Capstone && tooManyCapstones != nil:
 		return tooManyCapstones
 	case hitPieceLimit:
 		return pieceErr
+	case tg.OutOfStones(p) != nil:
+		return tg.OutOfStones(p)
 	}
 	return nil
 }
@@ -169,7 +229,6 @@ func (tg *TakGame) ValidatePlacement(p Placement) error {
 // ValidateMovement checks to see if a Movement order is okay to run.
 func (tg *TakGame) ValidateMovement(m Movement) error {
 
-	boardSize := tg.Size
 	var stackTop Piece
 
 	squareIsEmpty, emptyErr := tg.SquareIsEmpty(m.Coords)
@@ -194,7 +253,7 @@ func (tg *TakGame) ValidateMovement(m Movement) error {
 	}
 
 	switch {
-	case len(tg.TurnHistory) < 2:
+	case tg.InOpeningSwap():
 		return errors.New("first two turns must be opposite-color placements")
 	case stackTop.Color == White && tg.IsBlackTurn == true:
 		return errors.New("cannot move white-topped stack on black's turn")
@@ -204,14 +263,18 @@ func (tg *TakGame) ValidateMovement(m Movement) error {
 		return fmt.Errorf("Problem checking square %v: %v", m.Coords, emptyErr)
 	case squareIsEmpty == true:
 		return fmt.Errorf("Cannot move non-existent stack: unoccupied square %v", m.Coords)
+	case m.Carry < 1 || len(m.Drops) == 0:
+		return fmt.Errorf("Stack movement from %v must carry and drop at least one piece", m.Coords)
 	case m.Carry > stackHeight:
 		return fmt.Errorf("Stack at %v is %v high - cannot carry %v pieces", m.Coords, stackHeight, m.Carry)
-	case m.Carry > len(tg.GameBoard):
-		return fmt.Errorf("Requested carry of %v pieces exceeds board carry limit: %v", m.Carry, boardSize)
+	case m.Carry > tg.CarryLimit():
+		return fmt.Errorf("Requested carry of %v pieces exceeds board carry limit: %v", m.Carry, tg.CarryLimit())
 	case totalDrops > m.Carry:
 		return fmt.Errorf("Requested drops (%v) exceed number of pieces carried (%v)", m.Drops, m.Carry)
 	case minDrop < 1:
 		return fmt.Errorf("Stack movements (%v) include a drop less than 1: %v", m.Drops, minDrop)
+	case totalDrops < m.Carry:
+		return fmt.Errorf("Requested drops (%v) leave some of the %v carried pieces undropped", m.Drops, m.Carry)
 	case tg.WouldHitBoardBoundary(m) != nil:
 		return tg.WouldHitBoardBoundary(m)
 	case tg.WallInWay(m) != nil:
@@ -295,12 +358,10 @@ func (tg *TakGame) WallInWay(m Movement) error {
 
 // ValidatePiece checks to make sure a piece is described correctly
 func (p *Piece) ValidatePiece() error {
-	rColor := regexp.MustCompile("^((?i)black|white)$")
-	rType := regexp.MustCompile("^((?i)flat|wall|capstone)")
-	if goodPieceColor := rColor.FindString(p.Color); goodPieceColor == "" {
+	if goodPieceColor := colorRegexp.FindString(p.Color); goodPieceColor == "" {
 		return fmt.Errorf("Invalid piece color '%v'", p.Color)
 	}
-	if goodPieceType := rType.FindString(p.Orientation); goodPieceType == "" {
+	if goodPieceType := orientationRegexp.FindString(p.Orientation); goodPieceType == "" {
 		return fmt.Errorf("Invalid piece orientation '%v'", p.Orientation)
 	}
 	p.Color = strings.ToLower(p.Color)
@@ -312,8 +373,7 @@ func (p *Piece) ValidatePiece() error {
 func (tg *TakGame) TranslateCoords(coords string) (x int, y int, error error) {
 	coords = strings.ToLower(coords)
 	// look for coordinates in the form LetterNumber
-	r := regexp.MustCompile("^([a-h])([1-8])$")
-	validcoords := r.FindAllStringSubmatch(coords, -1)
+	validcoords := coordsRegexp.FindAllStringSubmatch(coords, -1)
 
 	if len(validcoords) <= 0 {
 		return -1, -1, fmt.Errorf("Could not interpret coordinates '%v'", coords)
@@ -376,54 +436,70 @@ func (tg *TakGame) SquareIsEmpty(coords string) (bool, error) {
 	return false, nil
 }
 
-// HitPieceLimit checks for hitting a player's piece limit. This will need to be thought out a little more thoroughly,
-// since running out of pieces is a game-end condition.
-func (tg *TakGame) HitPieceLimit() (bool, error) {
-	_, totalPlacedPieces := tg.CountAllPlacedPieces()
-
-	boardSize := len(tg.GameBoard)
-	if totalPlacedPieces[Black] >= PieceLimits[boardSize] {
-		return true, errors.New("Black player is out of pieces")
-	} else if totalPlacedPieces[White] >= PieceLimits[boardSize] {
-		return true, errors.New("White player is out of pieces")
-	}
-	return false, nil
-}
-
-// TooManyCapstones checks for the presence of too many capstones on the board and prevents placing another
-func (tg *TakGame) TooManyCapstones(p Placement) error {
-	capstones := map[string]int{
-		Black: 0,
-		White: 0,
+// CountReserves works out how many stones and capstones each player has left to place from the pieces on the board
+func (tg *TakGame) CountReserves() map[string]Reserve {
+	reserves := map[string]Reserve{
+		Black: {tg.StoneLimit(), tg.CapstoneLimit()},
+		White: {tg.StoneLimit(), tg.CapstoneLimit()},
 	}
-
-	rBlack := regexp.MustCompile("^(?i)black$")
-	rWhite := regexp.MustCompile("^(?i)white$")
 	for i := 0; i < len(tg.GameBoard); i++ {
 		for j := 0; j < len(tg.GameBoard); j++ {
-			if len(tg.GameBoard[i][j].Pieces) > 0 && tg.GameBoard[i][j].Pieces[0].Orientation == Capstone {
-				if rBlack.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
-					capstones[Black]++
-				} else if rWhite.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
-					capstones[White]++
+			for _, piece := range tg.GameBoard[i][j].Pieces {
+				color := strings.ToLower(piece.Color)
+				reserve, ok := reserves[color]
+				if !ok {
+					continue
+				}
+				// a wall flattened by a capstone is still a stone, so orientation only matters for capstones
+				if piece.Orientation == Capstone {
+					reserve.Capstones--
+				} else {
+					reserve.Stones--
 				}
+				reserves[color] = reserve
 			}
 		}
 	}
+	return reserves
+}
 
-	capstoneLimit := 0
+// UpdateReserves records each player's remaining pieces in the game, for anyone looking at its JSON
+func (tg *TakGame) UpdateReserves() {
+	reserves := tg.CountReserves()
+	tg.WhiteReserve = reserves[White]
+	tg.BlackReserve = reserves[Black]
+}
 
-	switch {
-	case len(tg.GameBoard) == 8:
-		capstoneLimit = 2
-	case len(tg.GameBoard) >= 5:
-		capstoneLimit = 1
+// HitPieceLimit checks whether either player has run out of pieces altogether, stones and capstones both,
+// which ends the game.
+func (tg *TakGame) HitPieceLimit() (bool, error) {
+	reserves := tg.CountReserves()
+	if reserves[Black].Stones <= 0 && reserves[Black].Capstones <= 0 {
+		return true, errors.New("Black player is out of pieces")
+	} else if reserves[White].Stones <= 0 && reserves[White].Capstones <= 0 {
+		return true, errors.New("White player is out of pieces")
 	}
+	return false, nil
+}
+
+// OutOfStones checks whether the player placing a flat or a wall has any stones left to do it with
+func (tg *TakGame) OutOfStones(p Placement) error {
+	color := strings.ToLower(p.Piece.Color)
+	if p.Piece.Orientation != Capstone && tg.CountReserves()[color].Stones <= 0 {
+		return fmt.Errorf("%v player has no stones left: only a capstone can be placed", color)
+	}
+	return nil
+}
+
+// TooManyCapstones checks whether the player has any capstones left in reserve, and prevents placing another if not
+func (tg *TakGame) TooManyCapstones(p Placement) error {
+	capstoneLimit := tg.CapstoneLimit()
+	reserves := tg.CountReserves()
 
 	if p.Piece.Orientation == Capstone {
-		if p.Piece.Color == White && capstones[White] >= capstoneLimit {
+		if p.Piece.Color == White && reserves[White].Capstones <= 0 {
 			return fmt.Errorf("Board has already reached white capstone limit: %v", capstoneLimit)
-		} else if p.Piece.Color == Black && capstones[Black] >= capstoneLimit {
+		} else if p.Piece.Color == Black && reserves[Black].Capstones <= 0 {
 			return fmt.Errorf("Board has already reached black capstone limit: %v", capstoneLimit)
 		}
 	}
@@ -456,8 +532,7 @@ func (tg *TakGame) WouldHitBoardBoundary(m Movement) error {
 
 // ValidMoveDirection checks that the move direction is correct
 func (tg *TakGame) ValidMoveDirection(m Movement) error {
-	r := regexp.MustCompile("^[+-<>]$")
-	goodDirection := r.MatchString(m.Direction)
+	goodDirection := directionRegexp.MatchString(m.Direction)
 	if goodDirection == false {
 		return fmt.Errorf("Invalid movement direction '%v'", m.Direction)
 	}
@@ -469,7 +544,7 @@ func (tg *TakGame) IsGameOver() bool {
 	pieceLimitReached, _ := tg.HitPieceLimit()
 	gameOver := false
 
-	if pieceLimitReached || tg.IsFlatWin() || tg.IsRoadWin(Black) || tg.IsRoadWin(White) {
+	if tg.Resignation || tg.AgreedDraw || tg.TimeForfeit || tg.Repetition || tg.Adjudicated || pieceLimitReached || tg.IsFlatWin() || tg.RoadWinner() != "" {
 		gameOver = true
 	}
 
@@ -498,7 +573,8 @@ func (tg *TakGame) IsFlatWin() bool {
 	return flatWin
 }
 
-// CountAllPlacedPieces counts how many black/white pieces top stacks on the board, as well as total placed pieces
+// CountAllPlacedPieces counts how many black/white flats top stacks on the board (walls and capstones don't count
+// toward a flat win), as well as total placed pieces
 func (tg *TakGame) CountAllPlacedPieces() (stackTops map[string]int, totalPlacedPieces map[string]int) {
 
 	stackTops = map[string]int{
@@ -510,20 +586,20 @@ func (tg *TakGame) CountAllPlacedPieces() (stackTops map[string]int, totalPlaced
 		Black: 0,
 		White: 0,
 	}
-	rBlack := regexp.MustCompile("^(?i)black$")
-	rWhite := regexp.MustCompile("^(?i)white$")
 	for i := 0; i < len(tg.GameBoard); i++ {
 		for j := 0; j < len(tg.GameBoard); j++ {
 			if len(tg.GameBoard[i][j].Pieces) > 0 {
-				if rBlack.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
-					stackTops[Black]++
-				} else if rWhite.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
-					stackTops[White]++
+				if tg.GameBoard[i][j].Pieces[0].Orientation == Flat {
+					if blackRegexp.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
+						stackTops[Black]++
+					} else if whiteRegexp.MatchString(tg.GameBoard[i][j].Pieces[0].Color) {
+						stackTops[White]++
+					}
 				}
 				for p := 0; p < len(tg.GameBoard[i][j].Pieces); p++ {
-					if rBlack.MatchString(tg.GameBoard[i][j].Pieces[p].Color) {
+					if blackRegexp.MatchString(tg.GameBoard[i][j].Pieces[p].Color) {
 						totalPlacedPieces[Black]++
-					} else if rWhite.MatchString(tg.GameBoard[i][j].Pieces[p].Color) {
+					} else if whiteRegexp.MatchString(tg.GameBoard[i][j].Pieces[p].Color) {
 						totalPlacedPieces[White]++
 					}
 				}
@@ -533,45 +609,86 @@ func (tg *TakGame) CountAllPlacedPieces() (stackTops map[string]int, totalPlaced
 	return stackTops, totalPlacedPieces
 }
 
-// WhoWins determines who has won the game
+// WhoWins determines who has won the game, and records the result in the game's winner fields
 func (tg *TakGame) WhoWins() (string, error) {
 	if tg.IsGameOver() == false {
 		return "", errors.New("game is not over, yet")
 	}
 	stackTops, _ := tg.CountAllPlacedPieces()
 	pieceLimitReached, _ := tg.HitPieceLimit()
+	roadWinner := tg.RoadWinner()
+	// flats are counted in halves, so that komi can break ties
+	whiteFlats := 2 * stackTops[White]
+	blackFlats := 2*stackTops[Black] + tg.Rules.HalfKomi
 
 	switch {
-	case tg.IsBlackTurn && tg.IsRoadWin(Black):
-		tg.BlackWinner = true
-		return "Black makes a road win!", nil
-	case tg.IsBlackTurn == false && tg.IsRoadWin(White): // this is where to start
-		tg.WhiteWinner = true
-		return "White makes a road win!", nil
-	case tg.IsRoadWin(Black):
-		tg.BlackWinner = true
+	// resignations, agreed draws, time forfeits, repetitions and adjudications have already been recorded, and the board has nothing to say about them
+	case tg.Resignation && tg.WhiteWinner:
+		return "Black resigns: White wins!", nil
+	case tg.Resignation && tg.BlackWinner:
+		return "White resigns: Black wins!", nil
+	case tg.AgreedDraw:
+		return "Draw agreed!", nil
+	case tg.Repetition:
+		return "Draw by repetition!", nil
+	case tg.Adjudicated:
+		return "Draw: the game reached its move limit!", nil
+	case tg.TimeForfeit && tg.WhiteWinner:
+		return "Black runs out of time: White wins!", nil
+	case tg.TimeForfeit && tg.BlackWinner:
+		return "White runs out of time: Black wins!", nil
+	case roadWinner == Black:
+		tg.recordResult(Black, byRoad)
 		return "Black makes a road win!", nil
-	case tg.IsRoadWin(White):
-		tg.WhiteWinner = true
+	case roadWinner == White:
+		tg.recordResult(White, byRoad)
 		return "White makes a road win!", nil
-	case tg.IsFlatWin() && stackTops[Black] > stackTops[White]:
-		tg.BlackWinner = true
+	case tg.IsFlatWin() && blackFlats > whiteFlats:
+		tg.recordResult(Black, byFlats)
 		return "Black makes a Flat Win!", nil
-	case tg.IsFlatWin() && stackTops[White] > stackTops[Black]:
-		tg.WhiteWinner = true
+	case tg.IsFlatWin() && whiteFlats > blackFlats:
+		tg.recordResult(White, byFlats)
 		return "White makes a Flat Win!", nil
-	case tg.IsFlatWin() && stackTops[White] == stackTops[Black]:
-		tg.DrawGame = true
+	case tg.IsFlatWin() && whiteFlats == blackFlats:
+		tg.recordResult("", byFlats)
 		return "Game ends in a draw!", nil
-	case pieceLimitReached && stackTops[Black] > stackTops[White]:
-		tg.BlackWinner = true
+	case pieceLimitReached && blackFlats > whiteFlats:
+		tg.recordResult(Black, byFlats)
 		return "Black makes a Flat win: piece limit reached!", nil
-	case pieceLimitReached && stackTops[White] > stackTops[Black]:
-		tg.WhiteWinner = true
+	case pieceLimitReached && whiteFlats > blackFlats:
+		tg.recordResult(White, byFlats)
 		return "White makes a Flat win: piece limit reached!", nil
-	case pieceLimitReached && stackTops[White] == stackTops[Black]:
-		tg.DrawGame = true
+	case pieceLimitReached && whiteFlats == blackFlats:
+		tg.recordResult("", byFlats)
 		return "Draw game: piece limit reached!", nil
 	}
 	return "", nil
 }
+
+// the ways a game can be decided, for recordResult
+const (
+	byRoad        = "road"
+	byFlats       = "flats"
+	byResignation = "resignation"
+	byAgreement   = "agreement"
+	byTime        = "time"
+	byRepetition  = "repetition"
+	byMoveLimit   = "move limit"
+)
+
+// recordResult sets all of the game's winner fields in one go, so that they can never disagree with each other.
+// A winner of "" records a draw.
+func (tg *TakGame) recordResult(winner string, how string) {
+	tg.GameWinner = winner
+	tg.BlackWinner = winner == Black
+	tg.WhiteWinner = winner == White
+	tg.DrawGame = winner == ""
+	tg.RoadWin = how == byRoad
+	tg.FlatWin = winner != "" && how == byFlats
+	tg.Resignation = how == byResignation
+	tg.AgreedDraw = how == byAgreement
+	tg.TimeForfeit = how == byTime
+	tg.Repetition = how == byRepetition
+	tg.Adjudicated = how == byMoveLimit
+	tg.Result = tg.ResultCode()
+}
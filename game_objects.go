@@ -78,37 +78,84 @@ type TakGame struct {
 	// the gameboard for this game, represented as stacks of Pieces
 	GameBoard GameBoard `json:"gameBoard"`
 	// Boolean indicator of whose turn it is
-	IsBlackTurn bool          `json:"isBlackTurn"`
-	BlackWinner bool          `json:"blackWinner"`
-	WhiteWinner bool          `json:"whiteWinner"`
-	RoadWin     bool          `json:"roadWin"`
-	FlatWin     bool          `json:"flatWin"`
-	DrawGame    bool          `json:"drawGame"`
-	GameOver    bool          `json:"gameOver"`
-	GameWinner  string        `json:"gameWinner"`
-	WinningPath WinningPath   `json:"winningPath"`
-	StartTime   time.Time     `json:"startTime"`
-	WinTime     time.Time     `json:"winTime"`
-	BlackPlayer string        `json:"blackPlayer"`
-	WhitePlayer string        `json:"whitePlayer"`
-	GameOwner   string        `json:"gameOwner"`
-	IsPublic    bool          `json:"isPublic"`
-	HasStarted  bool          `json:"hasStarted"`
-	Size        int           `json:"size"`
-	MoveCount   int           `json:"moveCount"`
-	TurnHistory []interface{} `json:"turnHistory"`
+	IsBlackTurn bool         `json:"isBlackTurn"`
+	BlackWinner bool         `json:"blackWinner"`
+	WhiteWinner bool         `json:"whiteWinner"`
+	RoadWin     bool         `json:"roadWin"`
+	FlatWin     bool         `json:"flatWin"`
+	DrawGame    bool         `json:"drawGame"`
+	GameOver    bool         `json:"gameOver"`
+	GameWinner  string       `json:"gameWinner"`
+	WinningPath WinningPath  `json:"winningPath"`
+	StartTime   time.Time    `json:"startTime"`
+	WinTime     time.Time    `json:"winTime"`
+	BlackPlayer string       `json:"blackPlayer"`
+	WhitePlayer string       `json:"whitePlayer"`
+	GameOwner   string       `json:"gameOwner"`
+	IsPublic    bool         `json:"isPublic"`
+	HasStarted  bool         `json:"hasStarted"`
+	Size        int          `json:"size"`
+	MoveCount   int          `json:"moveCount"`
+	TurnHistory []MoveRecord `json:"turnHistory"`
+	// InitialPosition is the TPS the game was set up from, if it didn't start on an empty board
+	InitialPosition string `json:"initialPosition,omitempty"`
+	// InitialPly counts the plies that had already been played when the game reached InitialPosition
+	InitialPly int `json:"initialPly,omitempty"`
+	// Rules holds any variations on the standard rules the game is played with
+	Rules GameRules `json:"rules"`
+	// Events records everything other than moves that happens in the game, like takebacks
+	Events []GameEvent `json:"events,omitempty"`
+	// PendingTakeback is a takeback one player has asked for, waiting on the other's answer
+	PendingTakeback *TakebackRequest `json:"pendingTakeback,omitempty"`
+	// DrawOffer is the username of a player offering a draw, waiting on the other's answer
+	DrawOffer string `json:"drawOffer,omitempty"`
+	// Resignation, AgreedDraw, TimeForfeit, Repetition and Adjudicated say the game was decided off the board.
+	// Adjudicated games were called a draw for going on too long, like tournament games that hit their ply cap.
+	Resignation bool `json:"resignation"`
+	AgreedDraw  bool `json:"agreedDraw"`
+	TimeForfeit bool `json:"timeForfeit"`
+	Repetition  bool `json:"repetition"`
+	Adjudicated bool `json:"adjudicated,omitempty"`
+	// Result is the game's result as a PTN result code, e.g. "R-0", "0-F", "1-0" or "1/2-1/2"
+	Result string `json:"result,omitempty"`
+	// the pieces each player still has left to place
+	WhiteReserve Reserve `json:"whiteReserve"`
+	BlackReserve Reserve `json:"blackReserve"`
+	// TimeControl is set for timed games, and Clock keeps track of how much time each player has left
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	Clock       *GameClock   `json:"clock,omitempty"`
+	// Rated games count for something, so players can't ask for analysis or hints until they're over
+	Rated bool `json:"rated"`
+	// Created is when the game was set up, for the lobby to sort by
+	Created time.Time `json:"created"`
 }
 
-// PieceLimits is a map of gridsize to piece limits per player
-// note there's no limit listed for 7x7 games, which are "rarely played"
+// Reserve is a player's stock of unplaced pieces. Stones can be played as flats or walls; capstones are kept separately.
+type Reserve struct {
+	Stones    int `json:"stones"`
+	Capstones int `json:"capstones"`
+}
+
+// PieceLimits is a map of gridsize to the number of stones (flats and walls) per player
 var PieceLimits = map[int]int{
 	3: 10,
 	4: 15,
 	5: 21,
 	6: 30,
+	7: 40,
 	8: 50,
 }
 
+// CapstoneLimits is a map of gridsize to the number of capstones per player, on top of their stones
+var CapstoneLimits = map[int]int{
+	3: 0,
+	4: 0,
+	5: 1,
+	6: 1,
+	7: 2,
+	8: 2,
+}
+
 // LetterMap converts Tak x-values (letters) to their start-at-zero grid index value. 8x8 games are the max size.
 var LetterMap = map[string]int{
 	"a": 0,
@@ -162,6 +209,7 @@ func MakeGame(size int) (*TakGame, error) {
 		// randomly select a first player with a bool
 		IsBlackTurn: (r.Intn(2) == 0),
 	}
+	newTakGame.UpdateReserves()
 
 	return &newTakGame, nil
 }
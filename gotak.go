@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/handlers"
@@ -32,8 +34,36 @@ var opts struct {
 	LoginDays int    `long:"logindays" description:"duration of time a JWT token is valid"`
 }
 
+// subcommand is anything gotak can be asked to do from the command line, other than serving games
+type subcommand interface {
+	Run() error
+}
+
+var (
+	parser      = flags.NewParser(&opts, flags.Default)
+	subcommands = map[string]subcommand{
+		"perft":      &perftCommand{},
+		"tei":        &teiCommand{},
+		"tournament": &tournamentCommand{},
+		"puzzles":    &puzzlesCommand{},
+	}
+)
+
 func init() {
 
+	parser.SubcommandsOptional = true
+	parser.AddCommand("perft", "Count legal move sequences", "Count every legal sequence of moves to a given depth, for checking the rules engine against other Tak engines", subcommands["perft"])
+	parser.AddCommand("tei", "Play as a TEI engine", "Play one of gotak's bots through the Tak Engine Interface on stdin and stdout, so other Tak programs can use it as an engine", subcommands["tei"])
+	parser.AddCommand("tournament", "Play bots against each other", "Play a series of games between two bots, alternating colors, writing each game out as PTN and summing up the results", subcommands["tournament"])
+	parser.AddCommand("puzzles", "Find puzzles in finished games", "Look through the ends of the finished games in the database for forced roads, and store them as puzzles", subcommands["puzzles"])
+
+	// flags overrule the config file: see below
+	parser.Parse()
+	if parser.Active != nil {
+		// subcommands don't need any of the server configuration
+		return
+	}
+
 	// read in the configuration file
 	viper.SetConfigName("conf")
 	viper.AddConfigPath(".")
@@ -46,9 +76,14 @@ func init() {
 	jwtSigningKey = viper.GetString("production.jwtSigningKey")
 	dbFile = viper.GetString("production.dbname")
 
-	// ... flags, however, overrule the config file. Replace any unset flag values with values from the config file.
-	flags.Parse(&opts)
+	// any TEI engines in the config file can be seated at games like the built-in bots, e.g. tiltak: "tiltak --tei"
+	for name, command := range viper.GetStringMapString("production.engines") {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			Bots[name] = &TEIEngine{Command: fields[0], Args: fields[1:]}
+		}
+	}
 
+	// ... flags, however, overrule the config file. Replace any unset flag values with values from the config file.
 	if opts.SSLkey == "" {
 		opts.SSLkey = sslKey
 	}
@@ -96,6 +131,14 @@ func init() {
 
 func main() {
 
+	if parser.Active != nil {
+		if err := subcommands[parser.Active.Name].Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", parser.Active.Name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// ensure the database is setup
 	sqliteDB, err := InitSQLiteDB(opts.DBfile)
 	if err != nil {
@@ -105,6 +148,8 @@ func main() {
 
 	// set up the live database behind a Datastore interface for our methods to run against
 	sqliteEnv := &DBenv{sqliteDB}
+	// the opening book comes from the games in the database, and grows with them
+	go sqliteEnv.refreshOpeningBook(time.Hour)
 	// Bind to a port and pass our router in, logging every request to Stdout
 	http.ListenAndServeTLS(":8000", sslCert, sslKey, handlers.LoggingHandler(os.Stdout, genRouter(sqliteEnv)))
 
@@ -119,12 +164,32 @@ func genRouter(env *DBenv) *mux.Router {
 	api := r.PathPrefix("/v1").Subrouter()
 	api.Handle("/login", errorHandler(env.Login)).Methods("POST")
 	api.Handle("/register", errorHandler(env.Register)).Methods("POST")
+	api.Handle("/openings/{boardSize}", checkedChain.Then(errorHandler(env.ExploreOpenings))).Methods("GET")
+	api.Handle("/lobby", checkedChain.Then(errorHandler(env.ListGames))).Methods("GET")
 
 	game := api.PathPrefix("/game").Subrouter()
+	// this has to come before /new/{boardSize}, which would otherwise swallow it
+	game.Handle("/new/tps", checkedChain.Then(errorHandler(env.NewGameFromTPS))).Methods("POST")
 	game.Handle("/new/{boardSize}", checkedChain.Then(errorHandler(env.NewGame))).Methods("POST")
 	game.Handle("/{gameID}/show", checkedChain.Then(errorHandler(env.ShowGame)))
+	game.Handle("/{gameID}/moves", checkedChain.Then(errorHandler(env.LegalMoves))).Methods("GET")
+	game.Handle("/{gameID}/analysis", checkedChain.Then(errorHandler(env.AnalyzeGame))).Methods("GET")
+	game.Handle("/{gameID}/hints", checkedChain.Then(errorHandler(env.HintMoves))).Methods("GET")
 	game.Handle("/{gameID}/sit", checkedChain.Then(errorHandler(env.TakeSeat)))
 	game.Handle("/{gameID}/{action}", checkedChain.Then(errorHandler(env.Action))).Methods("POST")
 
+	puzzle := api.PathPrefix("/puzzle").Subrouter()
+	puzzle.Handle("/new", checkedChain.Then(errorHandler(env.NewPuzzle))).Methods("POST")
+	puzzle.Handle("/next", checkedChain.Then(errorHandler(env.NextPuzzle))).Methods("GET")
+	puzzle.Handle("/history", checkedChain.Then(errorHandler(env.PuzzleHistory))).Methods("GET")
+	puzzle.Handle("/{puzzleID}", checkedChain.Then(errorHandler(env.ShowPuzzle))).Methods("GET")
+	puzzle.Handle("/{puzzleID}/solve", checkedChain.Then(errorHandler(env.SolvePuzzle))).Methods("POST")
+
+	seek := api.PathPrefix("/seek").Subrouter()
+	seek.Handle("/new", checkedChain.Then(errorHandler(env.NewSeek))).Methods("POST")
+	seek.Handle("/list", checkedChain.Then(errorHandler(env.ListSeeks))).Methods("GET")
+	seek.Handle("/{seekID}/accept", checkedChain.Then(errorHandler(env.AcceptSeek))).Methods("POST")
+	seek.Handle("/{seekID}/cancel", checkedChain.Then(errorHandler(env.CancelSeek))).Methods("POST")
+
 	return r
 }
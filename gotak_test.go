@@ -2,14 +2,19 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -27,6 +32,11 @@ func init() {
 // this mockDB satisies the "Datastore" interface by having the methods below
 type mockDB struct {
 	takgame    TakGame
+	games      []TakGame
+	puzzles    []Puzzle
+	attempts   []PuzzleAttempt
+	seeks      []Seek
+	players    []string
 	playerid   uuid.UUID
 	takplayer  TakPlayer
 	playername string
@@ -40,6 +50,23 @@ func (mdb *mockDB) RetrieveTakGame(id uuid.UUID) (*TakGame, error) {
 	log.Debug(fmt.Sprintf("retrieving game %v", mdb.takgame.GameID))
 	return &mdb.takgame, nil
 }
+func (mdb *mockDB) FinishedGames() ([]*TakGame, error) {
+	finished := []*TakGame{}
+	for i := range mdb.games {
+		if mdb.games[i].GameOver {
+			finished = append(finished, &mdb.games[i])
+		}
+	}
+	return finished, nil
+}
+func (mdb *mockDB) ListGames(f GameFilter, viewer string) ([]*TakGame, int, error) {
+	// the lobby's filtering happens in SQL, so TestLobby uses a real database and the mock just hands back every game
+	games := []*TakGame{}
+	for i := range mdb.games {
+		games = append(games, &mdb.games[i])
+	}
+	return games, len(games), nil
+}
 func (mdb *mockDB) StorePlayer(p *TakPlayer) error {
 	mdb.takplayer = *p
 	return nil
@@ -49,9 +76,94 @@ func (mdb *mockDB) RetrievePlayer(name string) (*TakPlayer, error) {
 }
 
 func (mdb *mockDB) PlayerExists(n string) bool {
+	for _, p := range mdb.players {
+		if p == n {
+			return true
+		}
+	}
 	return mdb.takplayer.Username == n
 }
 
+func (mdb *mockDB) StorePuzzle(p *Puzzle) error {
+	for i := range mdb.puzzles {
+		if mdb.puzzles[i].PuzzleID == p.PuzzleID {
+			mdb.puzzles[i] = *p
+			return nil
+		}
+	}
+	mdb.puzzles = append(mdb.puzzles, *p)
+	return nil
+}
+func (mdb *mockDB) RetrievePuzzle(id uuid.UUID) (*Puzzle, error) {
+	for i := range mdb.puzzles {
+		if mdb.puzzles[i].PuzzleID == id {
+			return &mdb.puzzles[i], nil
+		}
+	}
+	return nil, errors.New("No such puzzle found")
+}
+func (mdb *mockDB) Puzzles() ([]*Puzzle, error) {
+	puzzles := []*Puzzle{}
+	for i := range mdb.puzzles {
+		puzzles = append(puzzles, &mdb.puzzles[i])
+	}
+	return puzzles, nil
+}
+func (mdb *mockDB) StorePuzzleAttempt(a *PuzzleAttempt) error {
+	mdb.attempts = append(mdb.attempts, *a)
+	return nil
+}
+func (mdb *mockDB) PuzzleAttempts(username string) ([]*PuzzleAttempt, error) {
+	attempts := []*PuzzleAttempt{}
+	for i := range mdb.attempts {
+		if mdb.attempts[i].Username == username {
+			attempts = append(attempts, &mdb.attempts[i])
+		}
+	}
+	return attempts, nil
+}
+
+func (mdb *mockDB) StoreSeek(s *Seek) error {
+	mdb.seeks = append(mdb.seeks, *s)
+	return nil
+}
+func (mdb *mockDB) RetrieveSeek(id uuid.UUID) (*Seek, error) {
+	for i := range mdb.seeks {
+		if mdb.seeks[i].SeekID == id {
+			seek := mdb.seeks[i]
+			return &seek, nil
+		}
+	}
+	return nil, errors.New("No such seek found")
+}
+func (mdb *mockDB) DeleteSeek(id uuid.UUID) error {
+	for i := range mdb.seeks {
+		if mdb.seeks[i].SeekID == id {
+			mdb.seeks = append(mdb.seeks[:i], mdb.seeks[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("No such seek found")
+}
+func (mdb *mockDB) Seeks() ([]*Seek, error) {
+	seeks := []*Seek{}
+	for i := range mdb.seeks {
+		seeks = append(seeks, &mdb.seeks[i])
+	}
+	return seeks, nil
+}
+
+// historyActions strips a game's TurnHistory down to the bare Placements and Movements, for comparing with
+// games played at another time or by other players
+func historyActions(tg *TakGame) []interface{} {
+	actions := []interface{}{}
+	for _, record := range tg.TurnHistory {
+		action, _ := record.Action()
+		actions = append(actions, action)
+	}
+	return actions
+}
+
 func TestBoardTooBig(t *testing.T) {
 	testBoard, err := MakeGame(23)
 	if testBoard != nil || err.Error() != "board size must be in the range 3 to 8 squares" {
@@ -138,7 +250,7 @@ func TestNoPlacementOnOccupiedSquare(t *testing.T) {
 	testBoard.GameBoard[3][1] = Stack{[]Piece{whiteCap, blackFlat}}
 	testBoard.IsBlackTurn = false
 	// let's just pretend we've got a longer turn history already in place
-	testBoard.TurnHistory = append(testBoard.TurnHistory, Movement{}, Placement{})
+	testBoard.TurnHistory = append(testBoard.TurnHistory, MoveRecord{}, MoveRecord{})
 	testBoard.WhitePlayer = "testWhite"
 	testBoard.BlackPlayer = "testBlack"
 	log.Debug(fmt.Sprintf("turnhistory length: %v", len(testBoard.TurnHistory)))
@@ -178,7 +290,7 @@ func TestTurnTaking(t *testing.T) {
 	// d2
 	testBoard.GameBoard[3][1] = Stack{[]Piece{whiteCap, blackFlat}}
 	testBoard.IsBlackTurn = true
-	testBoard.TurnHistory = append(testBoard.TurnHistory, Movement{}, Placement{})
+	testBoard.TurnHistory = append(testBoard.TurnHistory, MoveRecord{}, MoveRecord{})
 	testBoard.WhitePlayer = "testWhite"
 	testBoard.BlackPlayer = "testBlack"
 
@@ -188,7 +300,7 @@ func TestTurnTaking(t *testing.T) {
 		Problem   error
 	}{
 		{Placement{Coords: "b5", Piece: blackFlat}, errors.New("bad placement request: Cannot place piece on occupied square b5")},
-		{Placement{Coords: "a1", Piece: whiteCap}, errors.New("bad placement request: Cannot place piece on occupied square a1")},
+		{Placement{Coords: "a1", Piece: blackCap}, errors.New("bad placement request: Cannot place piece on occupied square a1")},
 		{Placement{Coords: "b2", Piece: blackFlat}, nil},
 		{Placement{Coords: "a4", Piece: blackFlat}, errors.New("bad placement request: Cannot place black piece on white turn")},
 		{Placement{Coords: "b3", Piece: bogusFlat}, errors.New("bad placement request: Invalid piece color 'bogus'")},
@@ -220,7 +332,7 @@ func TestEmptySquareDetection(t *testing.T) {
 	// c3
 	testGame.GameBoard[2][2] = Stack{[]Piece{whiteWall}}
 	testGame.IsBlackTurn = false
-	testGame.TurnHistory = append(testGame.TurnHistory, Movement{}, Placement{})
+	testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
 	testGame.WhitePlayer = "testWhite"
 	testGame.BlackPlayer = "testBlack"
 
@@ -296,7 +408,7 @@ func TestValidMovement(t *testing.T) {
 	//d1
 	testBoard.GameBoard[3][0] = Stack{[]Piece{whiteFlat, blackFlat, whiteFlat, blackFlat, whiteFlat, blackFlat, whiteFlat, blackFlat}}
 	testBoard.IsBlackTurn = false
-	testBoard.TurnHistory = append(testBoard.TurnHistory, Placement{}, Placement{})
+	testBoard.TurnHistory = append(testBoard.TurnHistory, MoveRecord{}, MoveRecord{})
 
 	cases := []struct {
 		move    Movement
@@ -313,6 +425,9 @@ func TestValidMovement(t *testing.T) {
 		{Movement{Coords: "e1", Direction: "-", Carry: 1, Drops: []int{1}}, errors.New("Stack movement ([1]) would exceed bottom board edge")},
 		{Movement{Coords: "e1", Direction: ">", Carry: 1, Drops: []int{1}}, errors.New("Stack movement ([1]) would exceed right board edge")},
 		{Movement{Coords: "b2", Direction: "a", Carry: 1, Drops: []int{1}}, errors.New("Cannot move non-existent stack: unoccupied square b2")},
+		{Movement{Coords: "d1", Direction: "+", Carry: 5, Drops: []int{2, 2}}, errors.New("Requested drops ([2 2]) leave some of the 5 carried pieces undropped")},
+		{Movement{Coords: "d1", Direction: "+", Carry: 0, Drops: []int{}}, errors.New("Stack movement from d1 must carry and drop at least one piece")},
+		{Movement{Coords: "d1", Direction: "1", Carry: 1, Drops: []int{1}}, errors.New("can't parse move direction '1'")},
 	}
 
 	for _, c := range cases {
@@ -325,6 +440,42 @@ func TestValidMovement(t *testing.T) {
 
 }
 
+func TestMoveStackDirections(t *testing.T) {
+	cases := []struct {
+		from      string
+		direction string
+		squares   []string
+	}{
+		{"a1", "+", []string{"a2", "a3", "a4"}},
+		{"a5", "-", []string{"a4", "a3", "a2"}},
+		{"a3", ">", []string{"b3", "c3", "d3"}},
+		{"e3", "<", []string{"d3", "c3", "b3"}},
+	}
+
+	for _, c := range cases {
+		testGame, _ := MakeGame(5)
+		x, y, _ := testGame.TranslateCoords(c.from)
+		testGame.GameBoard[x][y] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
+		testGame.IsBlackTurn = false
+		testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
+		testGame.WhitePlayer = "testWhite"
+		testGame.BlackPlayer = "testBlack"
+
+		move := Movement{Coords: c.from, Direction: c.direction, Carry: 3, Drops: []int{1, 1, 1}}
+		if err := testGame.MoveStack(move); err != nil {
+			t.Errorf("problem moving %v%v: %v", c.from, c.direction, err)
+			continue
+		}
+		// the bottom of the carried stack is dropped first, so the capstone ends up furthest away
+		for i, want := range []Piece{whiteFlat, blackFlat, whiteCap} {
+			x, y, _ := testGame.TranslateCoords(c.squares[i])
+			if got := testGame.GameBoard[x][y]; !reflect.DeepEqual(got, Stack{[]Piece{want}}) {
+				t.Errorf("moving %v%v: wanted %v at %v, got %v", c.from, c.direction, want, c.squares[i], got)
+			}
+		}
+	}
+}
+
 func TestCoordsAround(t *testing.T) {
 	testGame, _ := MakeGame(5)
 
@@ -419,7 +570,7 @@ func TestPathSearch(t *testing.T) {
 	// b1
 	testGame.GameBoard[1][0] = Stack{[]Piece{blackCap, whiteFlat, blackFlat}}
 	// b2
-	testGame.GameBoard[1][1] = Stack{[]Piece{blackWall, whiteFlat, blackFlat}}
+	testGame.GameBoard[1][1] = Stack{[]Piece{blackFlat, whiteFlat, blackFlat}}
 	// c2
 	testGame.GameBoard[2][1] = Stack{[]Piece{blackFlat, blackFlat, whiteFlat, whiteFlat}}
 	// c3
@@ -512,7 +663,8 @@ func TestRoadWin(t *testing.T) {
 	}{
 		{whiteWin, true, "White makes a road win!", nil},
 		{blackWin, true, "Black makes a road win!", nil},
-		{notARoadWin, true, "White makes a Flat Win!", nil},
+		// walls and capstones don't count toward a flat win, which leaves two flats apiece
+		{notARoadWin, true, "Game ends in a draw!", nil},
 		{noWin, false, "", errors.New("game is not over, yet")},
 		{revWin, true, "Black makes a road win!", nil},
 	}
@@ -533,6 +685,120 @@ func TestRoadWin(t *testing.T) {
 
 }
 
+func TestRoadsIgnoreWalls(t *testing.T) {
+	testCases := []struct {
+		// top pieces along a straight line across the board, from a1 to the far edge
+		line      func(i int) Piece
+		direction string
+		isRoad    bool
+	}{
+		{func(i int) Piece { return whiteFlat }, WestEast, true},
+		{func(i int) Piece { return whiteFlat }, NorthSouth, true},
+		{func(i int) Piece { return whiteCap }, WestEast, true},
+		{func(i int) Piece { return whiteWall }, NorthSouth, false},
+		// a capstone in the middle of the line keeps the road going, a wall breaks it
+		{func(i int) Piece {
+			if i == 1 {
+				return whiteCap
+			}
+			return whiteFlat
+		}, NorthSouth, true},
+		{func(i int) Piece {
+			if i == 1 {
+				return whiteWall
+			}
+			return whiteFlat
+		}, WestEast, false},
+		// walls at either end are no good either
+		{func(i int) Piece {
+			if i == 0 {
+				return whiteWall
+			}
+			return whiteFlat
+		}, NorthSouth, false},
+		{func(i int) Piece {
+			if i == 0 {
+				return whiteFlat
+			}
+			return whiteWall
+		}, WestEast, false},
+	}
+
+	for size := 3; size <= 8; size++ {
+		for _, c := range testCases {
+			tg, _ := MakeGame(size)
+			for i := 0; i < size; i++ {
+				x, y := i, 0
+				if c.direction == NorthSouth {
+					x, y = 0, i
+				}
+				tg.GameBoard[x][y] = Stack{[]Piece{c.line(i), blackFlat}}
+			}
+			if isRoad := tg.IsRoadWin(White); isRoad != c.isRoad {
+				t.Errorf("%v: wanted road %v, got %v", tg.TPS(), c.isRoad, isRoad)
+			}
+			if tg.IsRoadWin(Black) {
+				t.Errorf("%v: found a black road made only of buried pieces", tg.TPS())
+			}
+		}
+	}
+
+	// a full board where black has more stacks, but fewer flats
+	flats, _ := GameFromTPS("2S,2C,1/2S,1,2/1,2S,1 1 5")
+	winner, err := flats.WhoWins()
+	if winner != "White makes a Flat Win!" || err != nil {
+		t.Errorf("wanted white to win on flats, got %v (%v)", winner, err)
+	}
+	if stackTops, _ := flats.CountAllPlacedPieces(); stackTops[White] != 4 || stackTops[Black] != 1 {
+		t.Errorf("wanted 4 white and 1 black flats, got %v", stackTops)
+	}
+}
+
+func TestDragonClause(t *testing.T) {
+	testCases := []struct {
+		// b2- uncovers a road for one player while finishing one for the other
+		tps         string
+		move        Movement
+		winner      string
+		whoWon      string
+		resultCode  string
+		winningPath WinningPath
+	}{
+		{"x3/2,21,2/1,x,1 1 4", Movement{Coords: "b2", Direction: "-", Carry: 1, Drops: []int{1}}, White, "White makes a road win!", "R-0", WinningPath{{0, 0}, {1, 0}, {2, 0}}},
+		{"x3/1,12,1/2,x,2 2 4", Movement{Coords: "b2", Direction: "-", Carry: 1, Drops: []int{1}}, Black, "Black makes a road win!", "0-R", WinningPath{{0, 0}, {1, 0}, {2, 0}}},
+		// uncovering only the opponent's road hands them the win
+		{"x3/2,21,2/1,x,x 1 4", Movement{Coords: "b2", Direction: "-", Carry: 1, Drops: []int{1}}, Black, "Black makes a road win!", "0-R", WinningPath{{0, 1}, {1, 1}, {2, 1}}},
+	}
+
+	for _, c := range testCases {
+		tg, _ := GameFromTPS(c.tps)
+		tg.WhitePlayer, tg.BlackPlayer = "testWhite", "testBlack"
+		if err := tg.MoveStack(c.move); err != nil {
+			t.Errorf("%v: unexpected error %v", c.tps, err)
+			continue
+		}
+		// the move itself should have settled the game, without anyone asking
+		if !tg.GameOver || tg.GameWinner != c.winner || !tg.RoadWin || tg.FlatWin || tg.DrawGame {
+			t.Errorf("%v: wanted a road win for %v, got gameOver %v, gameWinner '%v', roadWin %v, flatWin %v, drawGame %v", c.tps, c.winner, tg.GameOver, tg.GameWinner, tg.RoadWin, tg.FlatWin, tg.DrawGame)
+		}
+		if tg.BlackWinner == tg.WhiteWinner {
+			t.Errorf("%v: wanted exactly one winner, got blackWinner %v and whiteWinner %v", c.tps, tg.BlackWinner, tg.WhiteWinner)
+		}
+		if code := tg.ResultCode(); code != c.resultCode {
+			t.Errorf("%v: wanted result %v, got %v", c.tps, c.resultCode, code)
+		}
+		if !reflect.DeepEqual(tg.WinningPath, c.winningPath) {
+			t.Errorf("%v: wanted winning path %v, got %v", c.tps, c.winningPath, tg.WinningPath)
+		}
+		if whoWon, _ := tg.WhoWins(); whoWon != c.whoWon {
+			t.Errorf("%v: wanted '%v', got '%v'", c.tps, c.whoWon, whoWon)
+		}
+		if err := tg.PlacePiece(Placement{Coords: "c3", Piece: whiteFlat}); err == nil {
+			t.Errorf("%v: played on after the game ended", c.tps)
+		}
+	}
+}
+
 func TestGameEnd(t *testing.T) {
 	testOne, _ := MakeGame(4)
 	testOne.GameBoard[3][0] = Stack{[]Piece{whiteFlat}}
@@ -586,8 +852,9 @@ func TestGameEnd(t *testing.T) {
 		checkErr       error
 	}{
 		{testOne, false, "White makes a road win!", true, nil},
-		{testTwo, false, "Game ends in a draw!", true, nil},
-		{testThree, false, "Black makes a Flat Win!", true, nil},
+		// black's walls fill the board, but only flats count toward a flat win
+		{testTwo, false, "White makes a Flat Win!", true, nil},
+		{testThree, false, "White makes a Flat Win!", true, nil},
 	}
 	for _, c := range testCases {
 		isOverPreMove := c.game.IsGameOver()
@@ -623,7 +890,7 @@ func TestTooManyPieces(t *testing.T) {
 	testOne.GameBoard[2][1] = Stack{[]Piece{whiteWall, blackFlat, whiteFlat}}
 	testOne.GameBoard[2][2] = Stack{[]Piece{blackWall, whiteFlat, blackFlat}}
 	testOne.IsBlackTurn = false
-	testOne.TurnHistory = append(testOne.TurnHistory, Placement{}, Placement{})
+	testOne.TurnHistory = append(testOne.TurnHistory, MoveRecord{}, MoveRecord{})
 	testOne.WhitePlayer = "testWhite"
 	testOne.BlackPlayer = "testBlack"
 
@@ -635,7 +902,7 @@ func TestTooManyPieces(t *testing.T) {
 	testTwo.GameBoard[2][1] = Stack{[]Piece{whiteWall, blackFlat, whiteFlat}}
 	testTwo.GameBoard[2][2] = Stack{[]Piece{blackWall, whiteFlat, blackFlat}}
 	testTwo.IsBlackTurn = true
-	testTwo.TurnHistory = append(testTwo.TurnHistory, Placement{}, Placement{})
+	testTwo.TurnHistory = append(testTwo.TurnHistory, MoveRecord{}, MoveRecord{})
 	testTwo.WhitePlayer = "testWhite"
 	testTwo.BlackPlayer = "testBlack"
 
@@ -657,7 +924,7 @@ func TestTooManyPieces(t *testing.T) {
 		gameOver  bool
 	}{
 		{testOne, Placement{Coords: "b1", Piece: whiteFlat}, "White makes a Flat win: piece limit reached!", true},
-		{testTwo, Placement{Coords: "b1", Piece: blackWall}, "Black makes a Flat win: piece limit reached!", true},
+		{testTwo, Placement{Coords: "b1", Piece: blackFlat}, "Black makes a Flat win: piece limit reached!", true},
 		{testThree, Placement{Coords: "b1", Piece: blackWall}, "", false},
 	}
 
@@ -674,6 +941,56 @@ func TestTooManyPieces(t *testing.T) {
 
 }
 
+func TestReserves(t *testing.T) {
+	startingReserves := map[int]Reserve{
+		3: {10, 0},
+		4: {15, 0},
+		5: {21, 1},
+		6: {30, 1},
+		7: {40, 2},
+		8: {50, 2},
+	}
+	for size, reserve := range startingReserves {
+		tg, _ := MakeGame(size)
+		if tg.WhiteReserve != reserve || tg.BlackReserve != reserve {
+			t.Errorf("%vx%v: wanted starting reserves %v, got white %v and black %v", size, size, reserve, tg.WhiteReserve, tg.BlackReserve)
+		}
+	}
+
+	// white has placed all 21 stones, but still has a capstone in hand
+	tg, _ := GameFromTPS("1111111111,x4/11111111111,x4/x5/x5/2,x4 1 12")
+	tg.WhitePlayer, tg.BlackPlayer = "testWhite", "testBlack"
+	if tg.WhiteReserve != (Reserve{0, 1}) || tg.BlackReserve != (Reserve{20, 1}) {
+		t.Errorf("wanted reserves white {0 1} and black {20 1}, got white %v and black %v", tg.WhiteReserve, tg.BlackReserve)
+	}
+	if tg.IsGameOver() {
+		t.Errorf("game shouldn't be over while white has a capstone left")
+	}
+
+	testCases := []struct {
+		placement Placement
+		err       error
+	}{
+		{Placement{Coords: "c3", Piece: whiteFlat}, errors.New("bad placement request: white player has no stones left: only a capstone can be placed")},
+		{Placement{Coords: "c3", Piece: whiteWall}, errors.New("bad placement request: white player has no stones left: only a capstone can be placed")},
+		{Placement{Coords: "c3", Piece: whiteCap}, nil},
+	}
+	for _, c := range testCases {
+		if err := tg.PlacePiece(c.placement); !reflect.DeepEqual(err, c.err) {
+			t.Errorf("placing %v: wanted error '%v', got '%v'", c.placement.Piece, c.err, err)
+		}
+	}
+
+	// with the capstone gone too, white's reserve is empty and the game is over
+	if tg.WhiteReserve != (Reserve{0, 0}) || !tg.GameOver || tg.GameWinner != White || !tg.FlatWin {
+		t.Errorf("wanted a white flat win with white's reserve empty, got reserve %v, gameOver %v, gameWinner '%v'", tg.WhiteReserve, tg.GameOver, tg.GameWinner)
+	}
+	gameJSON, _ := json.Marshal(tg)
+	if !strings.Contains(string(gameJSON), `"whiteReserve":{"stones":0,"capstones":0},"blackReserve":{"stones":20,"capstones":1}`) {
+		t.Errorf("wanted reserves in the game JSON, got %s", gameJSON)
+	}
+}
+
 func TestDrawStackTops(t *testing.T) {
 
 	testGame, _ := MakeGame(3)
@@ -681,7 +998,7 @@ func TestDrawStackTops(t *testing.T) {
 	// b1
 	testGame.GameBoard[1][0] = Stack{[]Piece{blackCap, whiteFlat, blackFlat}}
 	// b2
-	testGame.GameBoard[1][1] = Stack{[]Piece{blackWall, whiteFlat, blackFlat}}
+	testGame.GameBoard[1][1] = Stack{[]Piece{blackFlat, whiteFlat, blackFlat}}
 	// c2
 	testGame.GameBoard[2][1] = Stack{[]Piece{blackFlat, blackFlat, whiteFlat, whiteFlat}}
 	// c3
@@ -716,7 +1033,7 @@ func TestCapstoneStomping(t *testing.T) {
 	testOne, _ := MakeGame(4)
 	testOne.GameBoard[0][0] = Stack{[]Piece{whiteFlat, whiteFlat, blackFlat}}
 	testOne.GameBoard[0][1] = Stack{[]Piece{blackWall}}
-	testOne.TurnHistory = append(testOne.TurnHistory, Placement{}, Placement{})
+	testOne.TurnHistory = append(testOne.TurnHistory, MoveRecord{}, MoveRecord{})
 	testOneMove := Movement{Direction: "+", Carry: 2, Drops: []int{1, 1}, Coords: "a1"}
 	testOne.IsBlackTurn = false
 	testOne.WhitePlayer = "testWhite"
@@ -725,7 +1042,7 @@ func TestCapstoneStomping(t *testing.T) {
 	testTwo, _ := MakeGame(4)
 	testTwo.GameBoard[0][0] = Stack{[]Piece{blackWall, whiteFlat, blackFlat}}
 	testTwo.GameBoard[1][0] = Stack{[]Piece{whiteCap}}
-	testTwo.TurnHistory = append(testTwo.TurnHistory, Placement{}, Placement{})
+	testTwo.TurnHistory = append(testTwo.TurnHistory, MoveRecord{}, MoveRecord{})
 	testTwoMove := Movement{Direction: "<", Carry: 1, Drops: []int{1}, Coords: "b1"}
 	testTwo.IsBlackTurn = false
 	testTwo.WhitePlayer = "testWhite"
@@ -736,7 +1053,7 @@ func TestCapstoneStomping(t *testing.T) {
 	testThree.GameBoard[2][3] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
 	//d4
 	testThree.GameBoard[3][3] = Stack{[]Piece{blackWall}}
-	testThree.TurnHistory = append(testThree.TurnHistory, Placement{}, Placement{})
+	testThree.TurnHistory = append(testThree.TurnHistory, MoveRecord{}, MoveRecord{})
 	testThree.WhitePlayer = "testWhite"
 	testThree.BlackPlayer = "testBlack"
 
@@ -748,7 +1065,7 @@ func TestCapstoneStomping(t *testing.T) {
 	testFour.GameBoard[2][3] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
 	//d4
 	testFour.GameBoard[3][3] = Stack{[]Piece{blackWall}}
-	testFour.TurnHistory = append(testFour.TurnHistory, Placement{}, Placement{})
+	testFour.TurnHistory = append(testFour.TurnHistory, MoveRecord{}, MoveRecord{})
 
 	testFourMove := Movement{Direction: ">", Carry: 2, Drops: []int{2}, Coords: "c4"}
 	testFour.IsBlackTurn = false
@@ -760,7 +1077,7 @@ func TestCapstoneStomping(t *testing.T) {
 	testFive.GameBoard[2][3] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
 	//d4
 	testFive.GameBoard[3][3] = Stack{[]Piece{blackCap}}
-	testFive.TurnHistory = append(testFive.TurnHistory, Placement{}, Placement{})
+	testFive.TurnHistory = append(testFive.TurnHistory, MoveRecord{}, MoveRecord{})
 	testFiveMove := Movement{Direction: ">", Carry: 1, Drops: []int{1}, Coords: "c4"}
 	testFive.IsBlackTurn = false
 	testFive.WhitePlayer = "testWhite"
@@ -868,7 +1185,7 @@ func TestMoveHandler(t *testing.T) {
 	testGame.GameBoard[2][3] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
 	testGame.BlackPlayer = "testBlack"
 	testGame.WhitePlayer = "testWhite"
-	testGame.TurnHistory = append(testGame.TurnHistory, Placement{}, Placement{})
+	testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
 	testGame.IsBlackTurn = false
 
 	desiredBoard := makeGameBoard(5)
@@ -912,6 +1229,114 @@ func TestMoveHandler(t *testing.T) {
 	}
 }
 
+func TestPTNHandler(t *testing.T) {
+	testWhite := TakPlayer{Username: "testWhite"}
+
+	testCases := []struct {
+		action      string
+		contentType string
+		body        string
+		code        int
+		resp        string
+		coords      string
+		stack       Stack
+	}{
+		{"ptn", "", "3c4>22", 422, "Problem decoding PTN: drops in '3c4>22' add up to 4, but 3 pieces are carried\n", "", Stack{}},
+		{"ptn", "", "c4^", 422, "Problem decoding PTN: could not parse PTN move 'c4^': unknown direction '^', use <, >, + or -\n", "", Stack{}},
+		{"ptn", "", "4c4>", 409, "problem playing 4c4>: invalid move: Stack at c4 is 3 high - cannot carry 4 pieces\n", "", Stack{}},
+		{"ptn", "", "3c4>12", 200, "", "e4", Stack{[]Piece{whiteCap, blackFlat}}},
+		{"place", "text/plain", "Sd2\n", 200, "", "d2", Stack{[]Piece{whiteWall}}},
+		{"move", "text/plain; charset=utf-8", "c4-", 200, "", "c3", Stack{[]Piece{whiteCap}}},
+		{"explode", "text/plain", "a1", 404, "unknown action 'explode': try place, move or ptn\n", "", Stack{}},
+	}
+	for _, c := range testCases {
+		testGame, _ := MakeGame(5)
+		testGame.GameBoard[2][3] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
+		testGame.BlackPlayer = "testBlack"
+		testGame.WhitePlayer = "testWhite"
+		testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
+		testGame.IsBlackTurn = false
+
+		mockEnv := DBenv{db: &mockDB{
+			takgame:    *testGame,
+			takplayer:  testWhite,
+			playername: "testWhite",
+		}}
+
+		playerToken := generateJWT(&testWhite, "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/%v/%v", testGame.GameID.String(), c.action), bytes.NewBufferString(c.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code {
+			t.Errorf("%v %v: wanted return code %v, got %v", c.action, c.body, c.code, resp.StatusCode)
+		}
+		if c.code != 200 && string(body) != c.resp {
+			t.Errorf("%v %v: wanted response '%v', got '%v'", c.action, c.body, c.resp, string(body))
+		}
+		if c.code == 200 {
+			var postMoveGame TakGame
+			json.Unmarshal(body, &postMoveGame)
+			if stack, _ := postMoveGame.SquareContents(c.coords); !reflect.DeepEqual(stack, c.stack) {
+				t.Errorf("%v %v: wanted %v at %v, got %v", c.action, c.body, c.stack, c.coords, stack)
+			}
+		}
+	}
+}
+
+func TestNewGameFromTPSHandler(t *testing.T) {
+	testBlack := TakPlayer{Username: "testBlack"}
+
+	testCases := []struct {
+		tps  string
+		code int
+		resp string
+	}{
+		{"x4/x,2,1,x/x,1C,x2/x4 2 3", 200, ""},
+		{"x4/x,2,1,x/x,1C,x2/x4 3 3", 422, "could not set up game from TPS: could not parse TPS 'x4/x,2,1,x/x,1C,x2/x4 3 3': expected rows, player to move and move number\n"},
+	}
+
+	for _, c := range testCases {
+		mockEnv := DBenv{db: &mockDB{
+			takplayer:  testBlack,
+			playername: "testBlack",
+		}}
+		playerToken := generateJWT(&(testBlack), "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/game/new/tps", bytes.NewBufferString(c.tps))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code {
+			t.Errorf("Wanted return code %v, got %v", c.code, resp.StatusCode)
+		}
+		if c.code != 200 {
+			if string(body) != c.resp {
+				t.Errorf("Wanted response '%v', got '%v'", c.resp, string(body))
+			}
+			continue
+		}
+		stored := mockEnv.db.(*mockDB).takgame
+		if stored.TPS() != c.tps || stored.GameOwner != "testBlack" || !stored.IsBlackTurn {
+			t.Errorf("stored game doesn't match TPS %v: got %v, owner %v", c.tps, stored.TPS(), stored.GameOwner)
+		}
+	}
+}
+
 func TestFirstTwoMoves(t *testing.T) {
 
 	testGame, _ := MakeGame(5)
@@ -934,7 +1359,8 @@ func TestFirstTwoMoves(t *testing.T) {
 		code  int
 	}{
 		{Placement{Piece: Piece{Color: Black, Orientation: Wall}, Coords: "a2"}, "problem placing piece at a2: bad placement request: first two placements must be of the opponent's color\n", 409},
-		{Placement{Piece: Piece{Color: White, Orientation: Wall}, Coords: "a2"}, "", 200},
+		{Placement{Piece: Piece{Color: White, Orientation: Wall}, Coords: "a2"}, "problem placing piece at a2: bad placement request: first two placements must be flat pieces\n", 409},
+		{Placement{Piece: Piece{Color: White, Orientation: Flat}, Coords: "a2"}, "", 200},
 	}
 
 	for _, c := range testCases {
@@ -974,11 +1400,23 @@ func TestNewGameHandler(t *testing.T) {
 	}}
 
 	testCases := []struct {
-		size int
-		code int
+		size  int
+		rules string
+		code  int
+		want  GameRules
 	}{
-		{4, 200},
-		{9, 500},
+		{4, "", 200, GameRules{}},
+		{9, "", 500, GameRules{}},
+		{6, `{"halfKomi": 4, "capstones": 2}`, 200, GameRules{HalfKomi: 4, Capstones: pieceCount(2)}},
+		{5, `{"capstones": 0}`, 200, GameRules{Capstones: pieceCount(0)}},
+		{5, `{"stones": 0}`, 400, GameRules{}},
+		{5, `{"noOpeningSwap": true, "carryLimit": 3}`, 200, GameRules{NoOpeningSwap: true, CarryLimit: 3}},
+		{5, `{"carryLimit": 6}`, 400, GameRules{}},
+		{5, `{"halfKomi": "lots"}`, 400, GameRules{}},
+		{5, `{"halfKomi": 5, "timeControl": {"initial": 600, "increment": 10}}`, 200, GameRules{HalfKomi: 5}},
+		{5, `{"timeControl": {"initial": 600, "increment": 10, "delay": 5}}`, 400, GameRules{}},
+		{5, `{"timeControl": "10+5"}`, 400, GameRules{}},
+		{5, `{"timeControl": {"initial": "600"}}`, 400, GameRules{}},
 	}
 
 	for _, c := range testCases {
@@ -986,7 +1424,7 @@ func TestNewGameHandler(t *testing.T) {
 		loginResp := TakJWT{}
 		json.Unmarshal(playerToken, &loginResp)
 		rec := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/new/%v", c.size), nil)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/new/%v", c.size), strings.NewReader(c.rules))
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
 
 		genRouter(&mockEnv).ServeHTTP(rec, req)
@@ -995,6 +1433,114 @@ func TestNewGameHandler(t *testing.T) {
 		if resp.StatusCode != c.code {
 			t.Errorf("Wanted return code %v, got %v", c.code, resp.StatusCode)
 		}
+		if c.code == 200 {
+			var newGame TakGame
+			json.NewDecoder(resp.Body).Decode(&newGame)
+			if !reflect.DeepEqual(newGame.Rules, c.want) {
+				t.Errorf("wanted rules %+v, got %+v", c.want, newGame.Rules)
+			}
+			if strings.Contains(c.rules, "timeControl") && (newGame.Clock == nil || newGame.Clock.WhiteBank != 600000) {
+				t.Errorf("wanted a clock with 10 minutes each, got %+v", newGame.Clock)
+			}
+		}
+	}
+}
+
+// pieceCount is a piece count override for GameRules
+func pieceCount(n int) *int {
+	return &n
+}
+
+func TestGameRules(t *testing.T) {
+	testCases := []struct {
+		tps    string
+		rules  GameRules
+		whoWon string
+	}{
+		// a full board, with white a flat up
+		{"1,2,1/2,1,2/1,2,x 2 5", GameRules{}, ""},
+		{"1,2,1/2,1,2/1,2,1 2 5", GameRules{}, "White makes a Flat Win!"},
+		{"1,2,1/2,1,2/1,2,1 2 5", GameRules{HalfKomi: 2}, "Game ends in a draw!"},
+		{"1,2,1/2,1,2/1,2,1 2 5", GameRules{HalfKomi: 3}, "Black makes a Flat Win!"},
+	}
+	for _, c := range testCases {
+		tg, _ := GameFromTPS(c.tps)
+		tg.Rules = c.rules
+		if whoWon, _ := tg.WhoWins(); whoWon != c.whoWon {
+			t.Errorf("%v with komi %v: wanted '%v', got '%v'", c.tps, c.rules.Komi(), c.whoWon, whoWon)
+		}
+	}
+
+	// custom piece counts: a 4x4 game with capstones, and few enough stones to run out
+	tg, _ := GameFromTPS("x4/x4/x4/1,2,x2 1 2")
+	tg.WhitePlayer, tg.BlackPlayer = "testWhite", "testBlack"
+	tg.Rules = GameRules{Stones: pieceCount(2), Capstones: pieceCount(1), CarryLimit: 1}
+	tg.UpdateReserves()
+	if tg.WhiteReserve != (Reserve{1, 1}) {
+		t.Errorf("wanted white reserve {1 1}, got %v", tg.WhiteReserve)
+	}
+	placements := []struct {
+		placement Placement
+		err       error
+	}{
+		{Placement{Coords: "a2", Piece: whiteCap}, nil},
+		{Placement{Coords: "b2", Piece: blackCap}, nil},
+		{Placement{Coords: "c2", Piece: whiteCap}, errors.New("bad placement request: Board has already reached white capstone limit: 1")},
+		{Placement{Coords: "c2", Piece: whiteFlat}, nil},
+	}
+	for _, c := range placements {
+		if err := tg.PlacePiece(c.placement); !reflect.DeepEqual(err, c.err) {
+			t.Errorf("placing %v: wanted error '%v', got '%v'", c.placement.Piece, c.err, err)
+		}
+	}
+	// white has used up both stones and the capstone
+	if !tg.GameOver || tg.WhiteReserve != (Reserve{0, 0}) {
+		t.Errorf("wanted the game over with white out of pieces, got gameOver %v and reserve %v", tg.GameOver, tg.WhiteReserve)
+	}
+
+	// a 5x5 game can be played without capstones, which PTN keeps track of with a Caps tag of 0
+	noCaps, _ := MakeGame(5)
+	noCaps.WhitePlayer, noCaps.BlackPlayer = "testWhite", "testBlack"
+	noCaps.IsBlackTurn = false
+	noCaps.Rules.NoOpeningSwap = true
+	noCaps.Rules.Capstones = pieceCount(0)
+	noCaps.UpdateReserves()
+	if noCaps.WhiteReserve != (Reserve{21, 0}) || noCaps.CapstoneLimit() != 0 {
+		t.Errorf("wanted white reserve {21 0} and no capstones, got %v and %v", noCaps.WhiteReserve, noCaps.CapstoneLimit())
+	}
+	if err := noCaps.PlacePiece(Placement{Coords: "a1", Piece: whiteCap}); err == nil {
+		t.Errorf("wanted a capstone placement turned down in a game without capstones")
+	}
+	if ptn, _ := noCaps.PTN(); !strings.Contains(ptn, "[Caps \"0\"]") {
+		t.Errorf("wanted a Caps tag of 0 in the PTN, got %v", ptn)
+	}
+
+	carry, _ := GameFromTPS("x3/x3/122,x2 2 4")
+	carry.Rules.CarryLimit = 2
+	if err := carry.ValidateMovement(Movement{Coords: "a1", Direction: ">", Carry: 3, Drops: []int{1, 2}}); !reflect.DeepEqual(err, errors.New("Requested carry of 3 pieces exceeds board carry limit: 2")) {
+		t.Errorf("wanted the carry limit enforced, got %v", err)
+	}
+
+	// without the opening swap, each player starts by placing their own pieces
+	noSwap, _ := MakeGame(5)
+	noSwap.WhitePlayer, noSwap.BlackPlayer = "testWhite", "testBlack"
+	noSwap.IsBlackTurn = false
+	noSwap.Rules.NoOpeningSwap = true
+	if err := noSwap.PlacePiece(Placement{Coords: "a1", Piece: whiteFlat}); err != nil {
+		t.Errorf("wanted white to place a white flat first, got %v", err)
+	}
+	if moves := noSwap.LegalMoveList(); len(moves.PTN) != 24*3 {
+		t.Errorf("wanted black to have flat, wall and capstone placements, got %v", moves.PTN)
+	}
+	// the rules survive a trip through PTN
+	noSwap.Rules.HalfKomi = 5
+	ptn, _ := noSwap.PTN()
+	if !strings.Contains(ptn, "[Komi \"2.5\"]\n[Opening \"no-swap\"]") {
+		t.Errorf("wanted Komi and Opening tags in the PTN, got %v", ptn)
+	}
+	parsed, _ := ParsePTN(ptn)
+	if replayed, err := parsed.Replay(); err != nil || !reflect.DeepEqual(replayed.Rules, noSwap.Rules) {
+		t.Errorf("wanted rules %+v after replaying the PTN, got %+v (%v)", noSwap.Rules, replayed, err)
 	}
 }
 
@@ -1029,3 +1575,1982 @@ func isJSON(s string) bool {
 	var js map[string]interface{}
 	return json.Unmarshal([]byte(s), &js) == nil
 }
+
+func TestParsePTN(t *testing.T) {
+	record := `[Site "PlayTak.com"]
+[Player1 "alice"]
+[Player2 "bob"]
+[Size "5"]
+[Result "R-0"]
+
+1. a1 e5 {the usual opening}
+2. b1 c3
+3. b1< Sc4'
+4.2a1>11 Cb3!
+R-0
+`
+	pg, err := ParsePTN(record)
+	if err != nil {
+		t.Fatalf("problem parsing PTN: %v", err)
+	}
+	wantPlies := []string{"a1", "e5", "b1", "c3", "b1<", "Sc4", "2a1>11", "Cb3"}
+	if !reflect.DeepEqual(pg.Plies, wantPlies) {
+		t.Errorf("wanted plies %v, got %v", wantPlies, pg.Plies)
+	}
+	if pg.Result != "R-0" || pg.Tag("player1") != "alice" || pg.Tag("Size") != "5" {
+		t.Errorf("wanted result R-0, player1 alice, size 5: got %v, %v, %v", pg.Result, pg.Tag("player1"), pg.Tag("Size"))
+	}
+
+	tg, err := pg.Replay()
+	if err != nil {
+		t.Fatalf("problem replaying PTN: %v", err)
+	}
+	wantTurns := []interface{}{
+		Placement{Piece: blackFlat, Coords: "a1"},
+		Placement{Piece: whiteFlat, Coords: "e5"},
+		Placement{Piece: whiteFlat, Coords: "b1"},
+		Placement{Piece: blackFlat, Coords: "c3"},
+		Movement{Coords: "b1", Direction: "<", Carry: 1, Drops: []int{1}},
+		Placement{Piece: blackWall, Coords: "c4"},
+		Movement{Coords: "a1", Direction: ">", Carry: 2, Drops: []int{1, 1}},
+		Placement{Piece: blackCap, Coords: "b3"},
+	}
+	if turns := historyActions(tg); !reflect.DeepEqual(turns, wantTurns) {
+		t.Errorf("wanted turn history\n%v\ngot\n%v", wantTurns, turns)
+	}
+	boardCases := []struct {
+		coords string
+		stack  Stack
+	}{
+		{"a1", Stack{[]Piece{}}},
+		{"b1", Stack{[]Piece{blackFlat}}},
+		{"c1", Stack{[]Piece{whiteFlat}}},
+		{"c4", Stack{[]Piece{blackWall}}},
+		{"b3", Stack{[]Piece{blackCap}}},
+	}
+	for _, c := range boardCases {
+		if stack, _ := tg.SquareContents(c.coords); !reflect.DeepEqual(stack, c.stack) {
+			t.Errorf("after replay, wanted %v at %v, got %v", c.stack, c.coords, stack)
+		}
+	}
+
+	badCases := []struct {
+		record  string
+		problem error
+	}{
+		{"1. a1 e9", errors.New("could not parse PTN move 'e9': no square between a1 and h8")},
+		{"[Size 5]\n1. a1", errors.New("could not parse PTN tag '[Size 5]'")},
+		{"1. a1 e5 R-0 c3", errors.New("unexpected 'c3' after game result R-0")},
+		{"1. a1 -- e5", errors.New("'--' is only allowed as the very first ply")},
+	}
+	for _, c := range badCases {
+		if _, err := ParsePTN(c.record); !reflect.DeepEqual(err, c.problem) {
+			t.Errorf("wanted error '%v', got '%v'", c.problem, err)
+		}
+	}
+}
+
+func TestPTNMoves(t *testing.T) {
+	testGame, _ := MakeGame(5)
+	testGame.IsBlackTurn = false
+	testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
+
+	cases := []struct {
+		ply     string
+		action  interface{}
+		problem error
+	}{
+		{"d4", Placement{Piece: whiteFlat, Coords: "d4"}, nil},
+		{"Sa2", Placement{Piece: whiteWall, Coords: "a2"}, nil},
+		{"Ce5", Placement{Piece: whiteCap, Coords: "e5"}, nil},
+		{"a1+", Movement{Coords: "a1", Direction: "+", Carry: 1, Drops: []int{1}}, nil},
+		{"3c3>", Movement{Coords: "c3", Direction: ">", Carry: 3, Drops: []int{3}}, nil},
+		{"3c3>12", Movement{Coords: "c3", Direction: ">", Carry: 3, Drops: []int{1, 2}}, nil},
+		{"4b2-221", nil, errors.New("drops in '4b2-221' add up to 5, but 4 pieces are carried")},
+		{"3c3>22", nil, errors.New("drops in '3c3>22' add up to 4, but 3 pieces are carried")},
+		{"Xa1", nil, errors.New("could not parse PTN move 'Xa1': unknown piece type 'X', use F, S or C")},
+		{"c3^", nil, errors.New("could not parse PTN move 'c3^': unknown direction '^', use <, >, + or -")},
+		{"3c3", nil, errors.New("could not parse PTN move '3c3': movement has no direction")},
+		{"9c3>", nil, errors.New("could not parse PTN move '9c3>': carry count '9' must be 1 to 8")},
+		{"2c3>1x", nil, errors.New("could not parse PTN move '2c3>1x': drop counts '1x' must be digits 1 to 8")},
+		{"", nil, errors.New("empty PTN move")},
+	}
+	for _, c := range cases {
+		action, err := testGame.ParsePTNMove(c.ply)
+		if !reflect.DeepEqual(err, c.problem) {
+			t.Errorf("%v: wanted error '%v', got '%v'", c.ply, c.problem, err)
+		}
+		if err == nil && !reflect.DeepEqual(action, c.action) {
+			t.Errorf("%v: wanted %v, got %v", c.ply, c.action, action)
+		}
+		if err == nil {
+			if ptn, _ := ActionPTN(action); ptn != c.ply {
+				t.Errorf("wanted %v to render as PTN '%v', got '%v'", action, c.ply, ptn)
+			}
+		}
+	}
+}
+
+func TestPTNRoundTrip(t *testing.T) {
+	testGame, _ := MakeGame(4)
+	testGame.WhitePlayer = "testWhite"
+	testGame.BlackPlayer = "testBlack"
+	testGame.IsBlackTurn = true
+
+	// black moves first in this one, so the record has to open with "--"
+	for _, ply := range []string{"a1", "a2", "b2", "b1", "a2-", "c4", "2a1>", "Sd1", "3b1+"} {
+		action, err := testGame.ParsePTNMove(ply)
+		if err != nil {
+			t.Fatalf("problem parsing %v: %v", ply, err)
+		}
+		if err := testGame.ApplyAction(action); err != nil {
+			t.Fatalf("problem playing %v: %v", ply, err)
+		}
+	}
+
+	record, err := testGame.PTN()
+	if err != nil {
+		t.Fatalf("problem writing PTN: %v", err)
+	}
+	pg, err := ParsePTN(record)
+	if err != nil {
+		t.Fatalf("problem re-reading PTN: %v\n%v", err, record)
+	}
+	if !pg.BlackFirst || pg.Tag("Player2") != "testBlack" || pg.Tag("Size") != "4" {
+		t.Errorf("PTN header didn't survive the round trip:\n%v", record)
+	}
+	replayed, err := pg.Replay()
+	if err != nil {
+		t.Fatalf("problem replaying written PTN: %v\n%v", err, record)
+	}
+	if !reflect.DeepEqual(replayed.GameBoard, testGame.GameBoard) || !reflect.DeepEqual(historyActions(replayed), historyActions(testGame)) {
+		t.Errorf("replayed game doesn't match the original:\n%v\n%v", replayed.DrawStackTops(), testGame.DrawStackTops())
+	}
+}
+
+func TestMoveRecords(t *testing.T) {
+	pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3 3. b1< Sc4 4. 2a1>11 Cb3")
+	tg, err := pg.Replay()
+	if err != nil {
+		t.Fatalf("problem replaying PTN: %v", err)
+	}
+
+	wantRecords := []MoveRecord{
+		{Type: PlaceMove, Player: "alice", Color: White, Piece: &blackFlat, Coords: "a1"},
+		{Type: PlaceMove, Player: "bob", Color: Black, Piece: &whiteFlat, Coords: "e5"},
+		{Type: PlaceMove, Player: "alice", Color: White, Piece: &whiteFlat, Coords: "b1"},
+		{Type: PlaceMove, Player: "bob", Color: Black, Piece: &blackFlat, Coords: "c3"},
+		{Type: StackMove, Player: "alice", Color: White, Coords: "b1", Direction: "<", Carry: 1, Drops: []int{1}},
+		{Type: PlaceMove, Player: "bob", Color: Black, Piece: &blackWall, Coords: "c4"},
+		{Type: StackMove, Player: "alice", Color: White, Coords: "a1", Direction: ">", Carry: 2, Drops: []int{1, 1}},
+		{Type: PlaceMove, Player: "bob", Color: Black, Piece: &blackCap, Coords: "b3"},
+	}
+	for i, record := range tg.TurnHistory {
+		if record.Time.IsZero() || record.PositionHash == "" {
+			t.Errorf("ply %v: wanted a time and a position hash, got %v and '%v'", i+1, record.Time, record.PositionHash)
+		}
+		record.Time, record.PositionHash = time.Time{}, ""
+		if !reflect.DeepEqual(record, wantRecords[i]) {
+			t.Errorf("ply %v: wanted %+v, got %+v", i+1, wantRecords[i], record)
+		}
+	}
+	if last := tg.TurnHistory[len(tg.TurnHistory)-1].PositionHash; last != tg.PositionHash() {
+		t.Errorf("wanted the last ply's hash to match the current position, got %v and %v", last, tg.PositionHash())
+	}
+
+	// the history survives a JSON round trip, and can be played again to reach the same position
+	gameJSON, _ := json.Marshal(tg)
+	var stored TakGame
+	if err := json.Unmarshal(gameJSON, &stored); err != nil {
+		t.Fatalf("problem decoding stored game: %v", err)
+	}
+	// (compared as JSON, since times lose their monotonic clock reading on the way through)
+	storedJSON, _ := json.Marshal(stored)
+	if !bytes.Equal(storedJSON, gameJSON) || len(stored.TurnHistory) != len(tg.TurnHistory) {
+		t.Errorf("turn history didn't survive JSON:\n%s\n%s", gameJSON, storedJSON)
+	}
+	replayed, _ := MakeGame(5)
+	replayed.WhitePlayer, replayed.BlackPlayer = "alice", "bob"
+	replayed.IsBlackTurn = false
+	for i, record := range stored.TurnHistory {
+		if err := replayed.ApplyAction(record); err != nil {
+			t.Fatalf("ply %v: problem replaying %+v: %v", i+1, record, err)
+		}
+		if replayed.PositionHash() != record.PositionHash {
+			t.Errorf("ply %v: replayed position %v doesn't match the recorded hash", i+1, replayed.TPS())
+		}
+	}
+
+	// games stored before the history was typed hold bare placements and movements
+	legacy := `[{"piece":{"color":"black","orientation":"flat"},"coords":"a1"},{"coords":"a1","direction":">","carry":1,"drops":[1]},{"coords":"b2"}]`
+	var records []MoveRecord
+	if err := json.Unmarshal([]byte(legacy), &records); err != nil {
+		t.Fatalf("problem decoding legacy history: %v", err)
+	}
+	wantActions := []interface{}{
+		Placement{Piece: blackFlat, Coords: "a1"},
+		Movement{Coords: "a1", Direction: ">", Carry: 1, Drops: []int{1}},
+	}
+	for i, want := range wantActions {
+		if action, err := records[i].Action(); err != nil || !reflect.DeepEqual(action, want) {
+			t.Errorf("legacy ply %v: wanted %v, got %v (%v)", i+1, want, action, err)
+		}
+	}
+	if _, err := records[2].Action(); !reflect.DeepEqual(err, errors.New("unknown move type ''")) {
+		t.Errorf("wanted an error for an unrecognizable legacy ply, got %v", err)
+	}
+}
+
+func TestTPS(t *testing.T) {
+	pg, _ := ParsePTN("[Size \"5\"]\n1. a1 e5 2. b1 c3 3. b1< Sc4 4. 2a1>11 Cb3")
+	replayed, err := pg.Replay()
+	if err != nil {
+		t.Fatalf("problem replaying PTN: %v", err)
+	}
+	if tps := replayed.TPS(); tps != "x4,1/x2,2S,x2/x,2C,2,x2/x5/x,2,1,x2 1 5" {
+		t.Errorf("wanted TPS 'x4,1/x2,2S,x2/x,2C,2,x2/x5/x,2,1,x2 1 5', got '%v'", tps)
+	}
+
+	cases := []struct {
+		tps         string
+		coords      string
+		stack       Stack
+		isBlackTurn bool
+		plyCount    int
+		problem     error
+	}{
+		{"x3/x,12C,x/x3 2 3", "b2", Stack{[]Piece{blackCap, whiteFlat}}, true, 5, nil},
+		{`[TPS "2,x3/x4/x4/x3,1 1 2"]`, "d1", Stack{[]Piece{whiteFlat}}, false, 2, nil},
+		{"x6/x6/x6/x6/x6/x5,2121S 1 12", "f1", Stack{[]Piece{whiteWall, blackFlat, whiteFlat, blackFlat}}, false, 22, nil},
+		{"x3/x3/x3 1 1", "a1", Stack{}, false, 0, nil},
+		{"x3/x3/x3", "", Stack{}, false, 0, errors.New("could not parse TPS 'x3/x3/x3': expected rows, player to move and move number")},
+		{"x3/x3 1 1", "", Stack{}, false, 0, errors.New("TPS has 2 rows: board size must be in the range 3 to 8 squares")},
+		{"x3/x4/x3 1 1", "", Stack{}, false, 0, errors.New("TPS row 2 describes 4 squares on a board 3 wide")},
+		{"x3/x2,1,1/x3 1 1", "", Stack{}, false, 0, errors.New("TPS row 2 describes more than 3 squares")},
+		{"x3/x,1S2,x/x3 1 1", "", Stack{}, false, 0, errors.New("could not parse TPS square '1S2' in row 2")},
+		{"x3/x3/x3 1 0", "", Stack{}, false, 0, errors.New("TPS move number must be at least 1")},
+	}
+	for _, c := range cases {
+		tg, err := GameFromTPS(c.tps)
+		if !reflect.DeepEqual(err, c.problem) {
+			t.Errorf("%v: wanted error '%v', got '%v'", c.tps, c.problem, err)
+		}
+		if err != nil {
+			continue
+		}
+		if stack, _ := tg.SquareContents(c.coords); !reflect.DeepEqual(stack, c.stack) {
+			t.Errorf("%v: wanted %v at %v, got %v", c.tps, c.stack, c.coords, stack)
+		}
+		if tg.IsBlackTurn != c.isBlackTurn || tg.PlyCount() != c.plyCount {
+			t.Errorf("%v: wanted black turn %v at ply %v, got %v at ply %v", c.tps, c.isBlackTurn, c.plyCount, tg.IsBlackTurn, tg.PlyCount())
+		}
+		// and back again
+		if !strings.Contains(c.tps, tg.TPS()) {
+			t.Errorf("%v: TPS didn't survive the round trip: got %v", c.tps, tg.TPS())
+		}
+	}
+
+	// a game set up from TPS should pick up its PTN numbering and opening rules from the position
+	fromTPS, _ := GameFromTPS("x3/x3/x3 2 1")
+	fromTPS.WhitePlayer = "testWhite"
+	fromTPS.BlackPlayer = "testBlack"
+	for _, ply := range []string{"a1", "b2", "c3", "a1>"} {
+		action, _ := fromTPS.ParsePTNMove(ply)
+		if err := fromTPS.ApplyAction(action); err != nil {
+			t.Fatalf("problem playing %v from TPS: %v", ply, err)
+		}
+	}
+	if stack, _ := fromTPS.SquareContents("b1"); !reflect.DeepEqual(stack, Stack{[]Piece{whiteFlat}}) {
+		t.Errorf("wanted the white flat black opened with on a1 to end up on b1, got %v", stack)
+	}
+	record, _ := fromTPS.PTN()
+	if !strings.Contains(record, "[TPS \"x3/x3/x3 2 1\"]") || !strings.Contains(record, "1. -- a1\n2. b2 c3\n3. a1>\n") {
+		t.Errorf("PTN from a TPS start position is wrong:\n%v", record)
+	}
+	pg, _ = ParsePTN(record)
+	if replayed, err := pg.Replay(); err != nil || replayed.TPS() != fromTPS.TPS() {
+		t.Errorf("replaying PTN from a TPS start gave %v, %v, wanted %v", replayed.TPS(), err, fromTPS.TPS())
+	}
+}
+
+func TestLegalMoves(t *testing.T) {
+	opening, _ := MakeGame(5)
+	opening.IsBlackTurn = false
+
+	threeByThree, _ := GameFromTPS("x3/x,2,x/1,x2 1 2")
+
+	stacked, _ := GameFromTPS("x5/x5/x,2S,x3/x5/x,21C,x3 1 10")
+
+	finished, _ := GameFromTPS("x3/x3/1,1,1 2 3")
+
+	testCases := []struct {
+		game       *TakGame
+		placements int
+		movements  []string
+	}{
+		{opening, 25, []string{}},
+		{threeByThree, 14, []string{"a1+", "a1>"}},
+		{stacked, 46, []string{"b1+", "2b1+", "2b1+11", "b1<", "2b1<", "b1>", "2b1>", "2b1>11"}},
+		{finished, 0, []string{}},
+	}
+
+	for _, c := range testCases {
+		list := c.game.LegalMoveList()
+		if len(list.Placements) != c.placements {
+			t.Errorf("%v: wanted %v placements, got %v", c.game.TPS(), c.placements, len(list.Placements))
+		}
+		movements := list.PTN[len(list.Placements):]
+		if !reflect.DeepEqual(movements, c.movements) {
+			t.Errorf("%v: wanted movements %v, got %v", c.game.TPS(), c.movements, movements)
+		}
+		// every last one of them had better be playable
+		for _, move := range c.game.LegalMoves() {
+			played := c.game.Copy()
+			played.WhitePlayer, played.BlackPlayer = "testWhite", "testBlack"
+			if err := played.ApplyAction(move); err != nil {
+				t.Errorf("%v: legal move %v couldn't be played: %v", c.game.TPS(), move, err)
+			}
+			if played.TPS() == c.game.TPS() {
+				t.Errorf("%v: playing %v on a copy changed the original", c.game.TPS(), move)
+			}
+		}
+	}
+
+	for _, p := range opening.LegalMoves() {
+		if p.(Placement).Piece != blackFlat {
+			t.Errorf("white's opening placement should be a black flat, got %v", p)
+		}
+	}
+
+	if drops := dropSequences(3, 2); !reflect.DeepEqual(drops, [][]int{{3}, {2, 1}, {1, 2}}) {
+		t.Errorf("wanted drop sequences [[3] [2 1] [1 2]], got %v", drops)
+	}
+}
+
+func TestLegalMovesHandler(t *testing.T) {
+	testGame, _ := GameFromTPS("x3/x,2,x/1,x2 1 2")
+	testGame.BlackPlayer = "testBlack"
+	testGame.WhitePlayer = "testWhite"
+	testWhite := TakPlayer{Username: "testWhite"}
+	mockEnv := DBenv{db: &mockDB{
+		takgame:    *testGame,
+		takplayer:  testWhite,
+		playername: "testWhite",
+	}}
+
+	playerToken := generateJWT(&testWhite, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/game/%v/moves", testGame.GameID.String()), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+	genRouter(&mockEnv).ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var list LegalMoveList
+	json.Unmarshal(body, &list)
+	if resp.StatusCode != 200 || len(list.Placements) != 14 || len(list.Movements) != 2 || len(list.PTN) != 16 {
+		t.Errorf("wanted 14 placements and 2 movements, got %v: %v", resp.StatusCode, string(body))
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	pg, _ := ParsePTN("[Player1 \"testWhite\"]\n[Player2 \"testBlack\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3 3. b1< Sc4")
+	tg, _ := pg.Replay()
+	finalTPS := tg.TPS()
+
+	testCases := []struct {
+		ply int
+		tps string
+		err error
+	}{
+		{0, "x5/x5/x5/x5/x5 1 1", nil},
+		{2, "x4,1/x5/x5/x5/2,x4 1 2", nil},
+		{5, "x4,1/x5/x2,2,x2/x5/21,x4 2 3", nil},
+		{6, finalTPS, nil},
+		{7, "", errors.New("ply 7 is out of range: this game runs from ply 0 to 6")},
+		{-1, "", errors.New("ply -1 is out of range: this game runs from ply 0 to 6")},
+	}
+	for _, c := range testCases {
+		past, err := tg.PositionAt(c.ply)
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("ply %v: wanted error '%v', got '%v'", c.ply, c.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if past.TPS() != c.tps || past.PlyCount() != c.ply || past.WhitePlayer != "testWhite" {
+			t.Errorf("ply %v: wanted %v, got %v after %v plies (white player '%v')", c.ply, c.tps, past.TPS(), past.PlyCount(), past.WhitePlayer)
+		}
+	}
+	if tg.TPS() != finalTPS || tg.PlyCount() != 6 {
+		t.Errorf("looking back changed the game: now %v after %v plies", tg.TPS(), tg.PlyCount())
+	}
+
+	// a game set up from TPS can only be taken back as far as its starting position
+	fromTPS, _ := GameFromTPS("x3/x,2,x/1,x2 1 2")
+	fromTPS.WhitePlayer, fromTPS.BlackPlayer = "testWhite", "testBlack"
+	fromTPS.PlacePiece(Placement{Coords: "c3", Piece: whiteFlat})
+	if past, err := fromTPS.PositionAt(2); err != nil || past.TPS() != "x3/x,2,x/1,x2 1 2" {
+		t.Errorf("wanted the starting position back, got %v (%v)", past, err)
+	}
+	if _, err := fromTPS.PositionAt(1); err == nil {
+		t.Errorf("wanted an error going back past the starting position")
+	}
+}
+
+func TestShowGameAtPly(t *testing.T) {
+	pg, _ := ParsePTN("[Player1 \"testWhite\"]\n[Player2 \"testBlack\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3 3. b1< Sc4")
+	testGame, _ := pg.Replay()
+	testWhite := TakPlayer{Username: "testWhite"}
+	mock := &mockDB{
+		takgame:    *testGame,
+		takplayer:  testWhite,
+		playername: "testWhite",
+	}
+	mockEnv := DBenv{db: mock}
+
+	testCases := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"ply=2&showtps=true", 200, "x4,1/x5/x5/x5/2,x4 1 2"},
+		{"ply=2&showtops=true", 200, `"| .  .  .  .  W |","| .  .  .  .  . |"`},
+		{"ply=5", 200, `"tps":"x4,1/x5/x2,2,x2/x5/21,x4 2 3"`},
+		{"ply=5", 200, `"ply":5,"moveNumber":3,"isBlackTurn":true`},
+		{"ply=two", 400, "could not understand requested ply: two"},
+		{"ply=9", 400, "could not show game at ply 9: ply 9 is out of range: this game runs from ply 0 to 6"},
+		{"showhash=true", 200, testGame.PositionHash()},
+		{"ply=5", 200, fmt.Sprintf(`"positionHash":"%v"`, testGame.TurnHistory[4].PositionHash)},
+	}
+	for _, c := range testCases {
+		playerToken := generateJWT(&testWhite, "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/game/%v/show?%v", testGame.GameID.String(), c.query), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code || !strings.Contains(string(body), c.body) {
+			t.Errorf("%v: wanted %v containing %v, got %v: %v", c.query, c.code, c.body, resp.StatusCode, string(body))
+		}
+	}
+	if mock.takgame.PlyCount() != 6 {
+		t.Errorf("showing an earlier ply changed the stored game")
+	}
+}
+
+func TestTakebacks(t *testing.T) {
+	pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3")
+	tg, _ := pg.Replay()
+
+	steps := []struct {
+		player  string
+		action  string
+		plies   int
+		err     error
+		tps     string
+		lastEvt string
+	}{
+		// bob has just moved, so by default he's asking to take back c3
+		{"bob", "request", 0, nil, "x4,1/x5/x2,2,x2/x5/2,1,x3 1 3", TakebackRequested},
+		{"bob", "accept", 0, errors.New("only bob's opponent can answer their takeback request"), "x4,1/x5/x2,2,x2/x5/2,1,x3 1 3", TakebackRequested},
+		{"carol", "accept", 0, errors.New("only bob's opponent can answer their takeback request"), "x4,1/x5/x2,2,x2/x5/2,1,x3 1 3", TakebackRequested},
+		{"alice", "request", 0, errors.New("bob has already asked for a takeback: accept or decline it first"), "x4,1/x5/x2,2,x2/x5/2,1,x3 1 3", TakebackRequested},
+		{"alice", "accept", 0, nil, "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackAccepted},
+		{"alice", "accept", 0, errors.New("no takeback has been asked for"), "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackAccepted},
+		// now it's bob's turn, so a full turn back undoes alice's b1 and his own e5
+		{"bob", "request", 0, nil, "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackRequested},
+		{"alice", "decline", 0, nil, "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackDeclined},
+		{"bob", "request", 3, errors.New("can only take back 1 or 2 plies, not 3"), "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackDeclined},
+		{"carol", "request", 1, errors.New("only the players in a game can ask for a takeback"), "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackDeclined},
+		{"bob", "request", 2, nil, "x4,1/x5/x5/x5/2,1,x3 2 2", TakebackRequested},
+		{"alice", "accept", 0, nil, "x5/x5/x5/x5/2,x4 2 1", TakebackAccepted},
+	}
+	for i, s := range steps {
+		var err error
+		switch s.action {
+		case "request":
+			err = tg.RequestTakeback(s.player, s.plies)
+		case "accept":
+			err = tg.AnswerTakeback(s.player, true)
+		case "decline":
+			err = tg.AnswerTakeback(s.player, false)
+		}
+		if !reflect.DeepEqual(err, s.err) {
+			t.Errorf("step %v: %v %v: wanted error '%v', got '%v'", i+1, s.player, s.action, s.err, err)
+		}
+		if tg.TPS() != s.tps {
+			t.Errorf("step %v: wanted position %v, got %v", i+1, s.tps, tg.TPS())
+		}
+		if last := tg.Events[len(tg.Events)-1]; last.Type != s.lastEvt {
+			t.Errorf("step %v: wanted last event %v, got %+v", i+1, s.lastEvt, last)
+		}
+	}
+	if undone := historyActions(&TakGame{TurnHistory: tg.Events[len(tg.Events)-1].Undone}); !reflect.DeepEqual(undone, []interface{}{Placement{whiteFlat, "e5"}, Placement{whiteFlat, "b1"}}) {
+		t.Errorf("wanted the takeback to record the undone e5 and b1, got %v", undone)
+	}
+	if tg.PlyCount() != 1 || !tg.IsBlackTurn || tg.BlackReserve.Stones != 20 || tg.WhiteReserve.Stones != 21 {
+		t.Errorf("wanted black to move after one ply, got %v plies, black's turn %v, reserves %v and %v", tg.PlyCount(), tg.IsBlackTurn, tg.WhiteReserve, tg.BlackReserve)
+	}
+
+	// playing on lets a pending request lapse
+	tg.RequestTakeback("alice", 1)
+	tg.PlacePiece(Placement{whiteFlat, "e5"})
+	if tg.PendingTakeback != nil || tg.Events[len(tg.Events)-1].Type != TakebackLapsed {
+		t.Errorf("wanted the takeback to lapse once bob moved, got %+v", tg.PendingTakeback)
+	}
+}
+
+func TestTakebackHandler(t *testing.T) {
+	pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3")
+	testGame, _ := pg.Replay()
+	mock := &mockDB{takgame: *testGame}
+	mockEnv := DBenv{db: mock}
+
+	testCases := []struct {
+		player string
+		action string
+		body   string
+		code   int
+		plies  int
+	}{
+		{"alice", "decline-takeback", "", 409, 4},
+		// alice can ask for a takeback even though it's her turn to move
+		{"alice", "request-takeback", `{"plies": 2}`, 200, 4},
+		{"bob", "accept-takeback", "", 200, 2},
+		{"bob", "request-takeback", `{"plies": "two"}`, 422, 2},
+	}
+	for _, c := range testCases {
+		player := TakPlayer{Username: c.player}
+		mock.takplayer = player
+		playerToken := generateJWT(&player, "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/%v/%v", testGame.GameID.String(), c.action), strings.NewReader(c.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		if resp.StatusCode != c.code || mock.takgame.PlyCount() != c.plies {
+			body, _ := ioutil.ReadAll(resp.Body)
+			t.Errorf("%v %v: wanted %v with %v plies played, got %v with %v: %v", c.player, c.action, c.code, c.plies, resp.StatusCode, mock.takgame.PlyCount(), string(body))
+		}
+	}
+}
+
+func TestResignAndDraws(t *testing.T) {
+	newGame := func() *TakGame {
+		pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"5\"]\n1. a1 e5 2. b1 c3")
+		tg, _ := pg.Replay()
+		return tg
+	}
+
+	resigned := newGame()
+	if err := resigned.Resign("carol"); err == nil {
+		t.Errorf("wanted an error when a spectator resigns, got none")
+	}
+	if err := resigned.Resign("bob"); err != nil || !resigned.GameOver || resigned.Result != "1-0" || resigned.GameWinner != White {
+		t.Errorf("wanted bob's resignation to give alice a 1-0 win, got %v, %v (%v)", resigned.Result, resigned.GameWinner, err)
+	}
+	if msg, _ := resigned.WhoWins(); msg != "Black resigns: White wins!" {
+		t.Errorf("wanted a resignation message, got %v", msg)
+	}
+	if err := resigned.OfferDraw("alice"); err == nil {
+		t.Errorf("wanted an error offering a draw in a finished game, got none")
+	}
+
+	whiteResigns := newGame()
+	whiteResigns.Resign("alice")
+	if whiteResigns.Result != "0-1" || whiteResigns.GameWinner != Black {
+		t.Errorf("wanted alice's resignation to give bob a 0-1 win, got %v, %v", whiteResigns.Result, whiteResigns.GameWinner)
+	}
+
+	drawn := newGame()
+	if err := drawn.AnswerDrawOffer("bob", true); err == nil {
+		t.Errorf("wanted an error accepting a draw nobody offered, got none")
+	}
+	drawn.OfferDraw("alice")
+	if err := drawn.OfferDraw("bob"); err == nil {
+		t.Errorf("wanted an error offering a draw over the top of alice's, got none")
+	}
+	if err := drawn.AnswerDrawOffer("alice", true); err == nil {
+		t.Errorf("wanted an error when alice accepts her own draw offer, got none")
+	}
+	if err := drawn.AnswerDrawOffer("bob", true); err != nil || !drawn.GameOver || !drawn.DrawGame || drawn.Result != "1/2-1/2" {
+		t.Errorf("wanted an agreed draw, got %v (%v)", drawn.Result, err)
+	}
+	if msg, _ := drawn.WhoWins(); msg != "Draw agreed!" {
+		t.Errorf("wanted an agreed draw message, got %v", msg)
+	}
+
+	declined := newGame()
+	declined.OfferDraw("alice")
+	declined.AnswerDrawOffer("bob", false)
+	if declined.GameOver || declined.DrawOffer != "" {
+		t.Errorf("wanted a declined draw to leave the game going with no offer standing, got %v, %v", declined.GameOver, declined.DrawOffer)
+	}
+
+	// alice offers a draw as she moves, and it stands until bob moves instead of answering
+	lapsed := newGame()
+	lapsed.OfferDraw("alice")
+	lapsed.PlacePiece(Placement{Piece{White, Flat}, "d1"})
+	if lapsed.DrawOffer != "alice" {
+		t.Errorf("wanted alice's draw offer to survive her own move, got %v", lapsed.DrawOffer)
+	}
+	lapsed.PlacePiece(Placement{Piece{Black, Flat}, "d5"})
+	if lapsed.DrawOffer != "" || lapsed.Events[len(lapsed.Events)-1].Type != DrawLapsed {
+		t.Errorf("wanted alice's draw offer to lapse once bob moved, got %v, %v", lapsed.DrawOffer, lapsed.Events)
+	}
+
+	// and the same over the API
+	testGame := newGame()
+	mock := &mockDB{takgame: *testGame}
+	mockEnv := DBenv{db: mock}
+
+	testCases := []struct {
+		player string
+		action string
+		code   int
+		result string
+	}{
+		{"alice", "accept-draw", 409, ""},
+		// it's alice's turn, so bob can't offer a draw
+		{"bob", "offer-draw", 400, ""},
+		{"alice", "offer-draw", 200, ""},
+		{"bob", "decline-draw", 200, ""},
+		{"alice", "offer-draw", 200, ""},
+		{"bob", "accept-draw", 200, "1/2-1/2"},
+		{"bob", "resign", 409, "1/2-1/2"},
+	}
+	for _, c := range testCases {
+		player := TakPlayer{Username: c.player}
+		mock.takplayer = player
+		playerToken := generateJWT(&player, "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/%v/%v", testGame.GameID.String(), c.action), strings.NewReader(""))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		if resp.StatusCode != c.code || mock.takgame.Result != c.result {
+			body, _ := ioutil.ReadAll(resp.Body)
+			t.Errorf("%v %v: wanted %v with result '%v', got %v with '%v': %v", c.player, c.action, c.code, c.result, resp.StatusCode, mock.takgame.Result, string(body))
+		}
+	}
+}
+
+func TestGameClock(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	clockNow = func() time.Time { return now }
+	defer func() { clockNow = time.Now }()
+
+	timedGame := func(tc TimeControl) *TakGame {
+		tg, _ := MakeGame(5)
+		tg.IsBlackTurn = false
+		tg.WhitePlayer, tg.BlackPlayer = "alice", "bob"
+		tg.TimeControl = &tc
+		tg.StartClock()
+		return tg
+	}
+	// play a flat on each of the given squares in turn, the given number of seconds apart
+	play := func(tg *TakGame, seconds int, squares ...string) {
+		for _, sq := range squares {
+			now = now.Add(time.Duration(seconds) * time.Second)
+			tg.UpdateClock()
+			color := White
+			if tg.IsBlackTurn {
+				color = Black
+			}
+			if tg.InOpeningSwap() {
+				color = oppositeColor(color)
+			}
+			tg.PlacePiece(Placement{Piece{color, Flat}, sq})
+			tg.PunchClock()
+		}
+	}
+
+	testCases := []struct {
+		tc           TimeControl
+		seconds      int
+		white, black int64
+	}{
+		// the first move starts the clock, so white's first 20 seconds are free
+		{TimeControl{Initial: 60}, 20, 20000, 20000},
+		{TimeControl{Initial: 60, Increment: 5}, 20, 35000, 30000},
+		{TimeControl{Initial: 60, Delay: 15}, 20, 50000, 50000},
+		// correspondence players get their full time back after every move
+		{TimeControl{DaysPerMove: 2}, 3600, 172800000, 172800000},
+	}
+	for _, c := range testCases {
+		now = start
+		tg := timedGame(c.tc)
+		play(tg, c.seconds, "a1", "b1", "c1", "d1", "e1")
+		if tg.GameOver || tg.Clock.WhiteBank != c.white || tg.Clock.BlackBank != c.black {
+			t.Errorf("%+v: wanted %v and %v ms left, got %v and %v (game over: %v)", c.tc, c.white, c.black, tg.Clock.WhiteBank, tg.Clock.BlackBank, tg.GameOver)
+		}
+	}
+
+	// black's turn: 40 seconds later black has 5 seconds left, and 10 seconds after that black's flag has fallen
+	now = start
+	flagged := timedGame(TimeControl{Initial: 60, Increment: 5})
+	play(flagged, 20, "a1", "b1", "c1")
+	now = now.Add(40 * time.Second)
+	if flagged.UpdateClock() || flagged.Clock.BlackRemaining != 5000 || flagged.Clock.WhiteRemaining != 50000 {
+		t.Errorf("wanted 50 and 5 seconds left, got %v and %v ms", flagged.Clock.WhiteRemaining, flagged.Clock.BlackRemaining)
+	}
+	now = now.Add(10 * time.Second)
+	if !flagged.UpdateClock() || !flagged.GameOver || !flagged.TimeForfeit || flagged.Result != "1-0" || flagged.Clock.BlackRemaining != 0 {
+		t.Errorf("wanted black to lose on time, got %v with %v ms left", flagged.Result, flagged.Clock.BlackRemaining)
+	}
+	if msg, _ := flagged.WhoWins(); msg != "Black runs out of time: White wins!" {
+		t.Errorf("wanted a time forfeit message, got %v", msg)
+	}
+	if err := flagged.PlacePiece(Placement{Piece{Black, Flat}, "e5"}); err == nil {
+		t.Errorf("wanted an error moving after losing on time, got none")
+	}
+	// once the game's over the clock stops
+	now = now.Add(time.Hour)
+	if flagged.UpdateClock() || flagged.Clock.WhiteRemaining != 50000 {
+		t.Errorf("wanted the clock to stop with the game, got %v ms left for white", flagged.Clock.WhiteRemaining)
+	}
+
+	// a takeback restarts the turn it goes back to: white isn't charged for black's thinking time, or the time it
+	// took to agree to the takeback
+	now = start
+	takenBack := timedGame(TimeControl{Initial: 60})
+	play(takenBack, 20, "a1", "b1", "c1")
+	now = now.Add(10 * time.Second)
+	takenBack.RequestTakeback("alice", 1)
+	now = now.Add(30 * time.Second)
+	if err := takenBack.AnswerTakeback("bob", true); err != nil || takenBack.IsBlackTurn || !takenBack.Clock.TurnStarted.Equal(now) {
+		t.Errorf("wanted white's turn restarted at %v, got %v (%v)", now, takenBack.Clock.TurnStarted, err)
+	}
+	now = now.Add(5 * time.Second)
+	if takenBack.UpdateClock() || takenBack.Clock.WhiteRemaining != 35000 || takenBack.Clock.BlackRemaining != 40000 {
+		t.Errorf("wanted 35 and 40 seconds left after the takeback, got %v and %v ms", takenBack.Clock.WhiteRemaining, takenBack.Clock.BlackRemaining)
+	}
+	// ... and taking the game back to the start stops the clock until the first move
+	takenBack.RequestTakeback("bob", 2)
+	takenBack.AnswerTakeback("alice", true)
+	now = now.Add(time.Hour)
+	if takenBack.PlyCount() != 0 || takenBack.UpdateClock() || !takenBack.Clock.TurnStarted.IsZero() {
+		t.Errorf("wanted the clock stopped at the start, got %v plies and %+v", takenBack.PlyCount(), takenBack.Clock)
+	}
+
+	badControls := []TimeControl{
+		{},
+		{Initial: -1},
+		{Initial: 60, Increment: 5, Delay: 5},
+		{DaysPerMove: 3, Initial: 60},
+	}
+	for _, tc := range badControls {
+		if tc.Validate() == nil {
+			t.Errorf("%+v: wanted a validation error, got none", tc)
+		}
+	}
+
+	// over the API: the flag fall is noticed, and stored, when the game's next fetched
+	now = start
+	apiGame := timedGame(TimeControl{Initial: 60})
+	play(apiGame, 10, "a1", "b1")
+	mock := &mockDB{takgame: *apiGame, takplayer: TakPlayer{Username: "alice"}}
+	mockEnv := DBenv{db: mock}
+	now = now.Add(61 * time.Second)
+
+	playerToken := generateJWT(&mock.takplayer, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/game/%v/show", apiGame.GameID.String()), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+	genRouter(&mockEnv).ServeHTTP(rec, req)
+
+	body, _ := ioutil.ReadAll(rec.Result().Body)
+	if !mock.takgame.TimeForfeit || mock.takgame.Result != "0-1" || !strings.Contains(string(body), `"whiteRemaining":0`) {
+		t.Errorf("wanted white's flag to fall when the game was shown, got %v: %v", mock.takgame.Result, string(body))
+	}
+}
+
+func TestRepetition(t *testing.T) {
+	newGame := func(rule string) *TakGame {
+		pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"5\"]\n1. a1 e5 2. b2 d4")
+		tg, _ := pg.Replay()
+		tg.Rules.Repetition = rule
+		return tg
+	}
+	// each player shuffles a flat over and back, which brings the position round again every four plies
+	shuffle := func(tg *TakGame, cycles int) {
+		for i := 0; i < cycles; i++ {
+			for _, ply := range []string{"b2>", "d4<", "c2<", "c4>"} {
+				action, _ := tg.ParsePTNMove(ply)
+				if err := tg.ApplyAction(action); err != nil {
+					t.Errorf("problem playing %v: %v", ply, err)
+				}
+			}
+		}
+	}
+
+	start := newGame("")
+	shuffled := newGame("")
+	shuffle(shuffled, 1)
+	if shuffled.PositionHash() != start.PositionHash() || shuffled.TPS() == start.TPS() || shuffled.RepetitionCount() != 2 {
+		t.Errorf("wanted the shuffle to repeat the position at a later move, got %v and %v, seen %v times", start.TPS(), shuffled.TPS(), shuffled.RepetitionCount())
+	}
+	if history := shuffled.PositionHistory(); len(history) != 9 || history[4] != history[8] || history[0] == history[4] {
+		t.Errorf("wanted 9 positions with the 5th and 9th the same, got %v", history)
+	}
+
+	// the same position reached by a different order of moves hashes the same
+	pg, _ := ParsePTN("[Size \"5\"]\n1. a1 e5 2. b2 d4 3. c3")
+	one, _ := pg.Replay()
+	pg, _ = ParsePTN("[Size \"5\"]\n1. a1 e5 2. c3 d4 3. b2")
+	other, _ := pg.Replay()
+	if one.PositionHash() != other.PositionHash() || one.PositionHash() == start.PositionHash() {
+		t.Errorf("wanted a transposition to hash the same, got %v and %v", one.PositionHash(), other.PositionHash())
+	}
+
+	testCases := []struct {
+		rule     string
+		gameOver bool
+		claimed  bool
+	}{
+		{"", false, false},
+		{RepetitionDraws, true, false},
+		{RepetitionClaims, false, true},
+	}
+	for _, c := range testCases {
+		tg := newGame(c.rule)
+		shuffle(tg, 1)
+		if tg.GameOver || tg.ClaimRepetition("alice") == nil {
+			t.Errorf("%v: wanted no draw after the position came up twice", c.rule)
+		}
+		shuffle(tg, 1)
+		if tg.GameOver != c.gameOver {
+			t.Errorf("%v: wanted game over to be %v after the position came up three times, got %v", c.rule, c.gameOver, tg.GameOver)
+		}
+		if err := tg.ClaimRepetition("bob"); (err == nil) != c.claimed {
+			t.Errorf("%v: wanted a claim to succeed: %v, got %v", c.rule, c.claimed, err)
+		}
+		if c.gameOver || c.claimed {
+			if msg, _ := tg.WhoWins(); !tg.Repetition || tg.Result != "1/2-1/2" || msg != "Draw by repetition!" {
+				t.Errorf("%v: wanted a draw by repetition, got %v: %v", c.rule, tg.Result, msg)
+			}
+		}
+	}
+
+	if err := (GameRules{Repetition: "sometimes"}).Validate(5); err == nil {
+		t.Errorf("wanted an error for an unknown repetition rule, got none")
+	}
+}
+
+func TestRoadAnalysis(t *testing.T) {
+	testCases := []struct {
+		tps       string
+		depth     int
+		white     []string
+		black     []string
+		tak       bool
+		forcedWin string
+		tinue     bool
+	}{
+		// white threatens e1, and black can block it
+		{"2,x4/x5/x5/x5/1,1,1,1,x 2 5", 1, []string{"e1", "Ce1"}, []string{}, true, "", false},
+		// ... but not e1 and e3 at once
+		{"2,x4/x5/1,1,1,1,x/x5/1,1,1,1,x 2 6", 1, []string{"e1", "Ce1", "e3", "Ce3"}, []string{}, true, "", true},
+		// with white to move, it's just a win
+		{"2,x4/x5/1,1,1,1,x/x5/1,1,1,1,x 1 6", 1, []string{"e1", "Ce1", "e3", "Ce3"}, []string{}, false, "e1", false},
+		// no threats yet, but d1 makes two of them: e1, and d2 to join up with e2
+		{"2,x4/x5/x5/x4,1/1,1,1,x2 1 5", 1, []string{}, []string{}, false, "", false},
+		{"2,x4/x5/x5/x4,1/1,1,1,x2 1 5", 2, []string{}, []string{}, false, "d1", false},
+		// walls can't finish a road, but a capstone can flatten one that's in the way
+		{"2,x4/x5/x5/x5/1,1,1,1,2S 1 5", 1, []string{}, []string{}, false, "", false},
+	}
+	for _, c := range testCases {
+		tg, err := GameFromTPS(c.tps)
+		if err != nil {
+			t.Errorf("%v: problem setting up: %v", c.tps, err)
+			continue
+		}
+		a := tg.Analyze(c.depth)
+		if !reflect.DeepEqual(a.WhiteRoadWins, c.white) || !reflect.DeepEqual(a.BlackRoadWins, c.black) {
+			t.Errorf("%v: wanted road wins %v and %v, got %v and %v", c.tps, c.white, c.black, a.WhiteRoadWins, a.BlackRoadWins)
+		}
+		if a.Tak != c.tak || a.ForcedWin != c.forcedWin || a.Tinue != c.tinue {
+			t.Errorf("%v at depth %v: wanted tak %v, forced win '%v', tinue %v, got %v, '%v', %v", c.tps, c.depth, c.tak, c.forcedWin, c.tinue, a.Tak, a.ForcedWin, a.Tinue)
+		}
+		if tg.TPS() != c.tps {
+			t.Errorf("%v: analysis changed the game to %v", c.tps, tg.TPS())
+		}
+	}
+
+	// and over the API, at an earlier ply
+	pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"4\"]\n1. d4 a1 2. b1 d3 3. c1 d2")
+	testGame, _ := pg.Replay()
+	mock := &mockDB{takgame: *testGame, takplayer: TakPlayer{Username: "alice"}}
+	mockEnv := DBenv{db: mock}
+	playerToken := generateJWT(&mock.takplayer, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+
+	// like hints, analysis is kept from spectators and rated games until they're over
+	testGame.IsPublic = true
+	rated := testGame.Copy()
+	rated.Rated = true
+	over := rated.Copy()
+	over.Resignation = true
+
+	apiCases := []struct {
+		game   *TakGame
+		player string
+		query  string
+		code   int
+		body   string
+	}{
+		// both players need d1, and it's white's turn
+		{testGame, "alice", "", 200, `"whiteRoadWins":["d1"],"blackRoadWins":["d1"],"tak":true,"depth":1,"forcedWin":"d1"`},
+		{testGame, "alice", "ply=5", 200, `"toMove":"black","whiteRoadWins":["d1"],"blackRoadWins":[],"tak":true`},
+		{testGame, "alice", "ply=5&depth=2", 200, `"depth":2`},
+		{testGame, "alice", "depth=9", 400, "depth must be a number from 1 to 2"},
+		{testGame, "alice", "ply=12", 400, "could not analyze game at ply 12"},
+		{testGame, "carol", "", 403, "only this game's players get analysis or hints until it's over"},
+		{rated, "alice", "", 403, "no analysis or hints until this rated game is over"},
+		{rated, "alice", "ply=5", 403, "no analysis or hints until this rated game is over"},
+		{over, "carol", "ply=5", 200, `"toMove":"black","whiteRoadWins":["d1"]`},
+	}
+	for _, c := range apiCases {
+		mock.takgame, mock.takplayer = *c.game, TakPlayer{Username: c.player}
+		playerToken := generateJWT(&mock.takplayer, "test")
+		json.Unmarshal(playerToken, &loginResp)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/game/%v/analysis?%v", testGame.GameID.String(), c.query), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code || !strings.Contains(string(body), c.body) {
+			t.Errorf("%v as %v: wanted %v containing %v, got %v: %v", c.query, c.player, c.code, c.body, resp.StatusCode, string(body))
+		}
+	}
+}
+
+func TestHints(t *testing.T) {
+	// white can win at e1, or with a capstone there
+	win, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 1 5")
+	h := win.SuggestMoves(3, 2, time.Minute)
+	if len(h.Moves) != 3 || h.ToMove != White || h.Depth != 2 {
+		t.Fatalf("wanted 3 hints for white at depth 2, got %+v", h)
+	}
+	for _, hint := range h.Moves[:2] {
+		if (hint.Move != "e1" && hint.Move != "Ce1") || hint.WinIn != 1 || !reflect.DeepEqual(hint.Variation, []string{hint.Move}) {
+			t.Errorf("wanted the road wins first, got %+v", hint)
+		}
+	}
+	if h.Moves[2].WinIn != 0 || h.Moves[2].Score > h.Moves[1].Score || len(h.Moves[2].Variation) != 2 {
+		t.Errorf("wanted a slower move third, with black's reply, got %+v", h.Moves[2])
+	}
+	if win.TPS() != "2,x4/x5/x5/x5/1,1,1,1,x 1 5" {
+		t.Errorf("hints changed the game to %v", win.TPS())
+	}
+
+	// black has to block at e1, or lose next move
+	block, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 2 5")
+	h = block.SuggestMoves(10, 2, time.Minute)
+	if h.Moves[0].Move != "e1" || h.Moves[0].LossIn != 0 || h.Moves[len(h.Moves)-1].LossIn != 2 {
+		t.Errorf("wanted e1 to be the only move that doesn't lose, got %+v", h.Moves)
+	}
+
+	// a deep search on a big board gives way to the deepest one that fits in the time
+	big, _ := GameFromTPS("x8/x8/x8/x3,1,2,x3/x3,2,1,x3/x8/x8/x8 1 3")
+	start := time.Now()
+	h = big.SuggestMoves(3, 3, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second || len(h.Moves) != 3 || h.Depth < 1 || h.Depth > 2 {
+		t.Errorf("wanted 3 hints from a search cut short within the budget, got %v at depth %v after %v", len(h.Moves), h.Depth, elapsed)
+	}
+
+	// and over the API, where they're kept from spectators and rated games until they're over
+	pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"4\"]\n1. d4 a1 2. b1 d3 3. c1 d2")
+	testGame, _ := pg.Replay()
+	testGame.IsPublic = true
+	rated := testGame.Copy()
+	rated.Rated = true
+	over := rated.Copy()
+	over.Resignation = true
+
+	apiCases := []struct {
+		game   *TakGame
+		player string
+		query  string
+		code   int
+		body   string
+	}{
+		{testGame, "alice", "", 200, `"toMove":"white","depth":2`},
+		{testGame, "alice", "count=1&depth=1", 200, `"moves":[{"move":"d1","score":999999,"variation":["d1"],"winIn":1}]`},
+		{testGame, "alice", "ply=5&count=1", 200, `"toMove":"black"`},
+		{testGame, "alice", "count=0", 400, "count must be a number from 1 to 10"},
+		{testGame, "alice", "depth=4", 400, "depth must be a number from 1 to 3"},
+		{testGame, "carol", "", 403, "only this game's players get analysis or hints until it's over"},
+		{rated, "alice", "", 403, "no analysis or hints until this rated game is over"},
+		{over, "carol", "ply=5&count=1", 200, `"ply":5`},
+	}
+	for _, c := range apiCases {
+		mock := &mockDB{takgame: *c.game, takplayer: TakPlayer{Username: c.player}}
+		mockEnv := DBenv{db: mock}
+		playerToken := generateJWT(&mock.takplayer, "test")
+		loginResp := TakJWT{}
+		json.Unmarshal(playerToken, &loginResp)
+
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/game/%v/hints?%v", testGame.GameID.String(), c.query), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code || !strings.Contains(string(body), c.body) {
+			t.Errorf("%v as %v: wanted %v containing %v, got %v: %v", c.query, c.player, c.code, c.body, resp.StatusCode, string(body))
+		}
+	}
+}
+
+func TestOpeningBook(t *testing.T) {
+	// three games open a1 d4 and one d1 a4; white wins two of the first three, and black the last
+	openings := []struct {
+		ptn    string
+		resign string
+	}{
+		{"1. a1 d4 2. b2", "bob"},
+		{"1. a1 d4 2. c3", "bob"},
+		{"1. a1 d4 2. b2 c2", "alice"},
+		{"1. d1 a4", "alice"},
+	}
+	mock := &mockDB{takplayer: TakPlayer{Username: "alice"}}
+	for _, o := range openings {
+		pg, _ := ParsePTN("[Player1 \"alice\"]\n[Player2 \"bob\"]\n[Size \"4\"]\n" + o.ptn)
+		tg, err := pg.Replay()
+		if err != nil {
+			t.Fatalf("%v: problem replaying: %v", o.ptn, err)
+		}
+		tg.Resign(o.resign)
+		mock.games = append(mock.games, *tg)
+	}
+	// a game that's still going doesn't count
+	pg, _ := ParsePTN("[Size \"4\"]\n1. d1 a4")
+	unfinished, _ := pg.Replay()
+	mock.games = append(mock.games, *unfinished)
+
+	mockEnv := DBenv{db: mock}
+	if err := mockEnv.loadOpeningBook(); err != nil {
+		t.Fatalf("problem building the opening book: %v", err)
+	}
+	defer func() { openingBook = NewOpeningBook(nil, bookPlies) }()
+
+	start, _ := MakeGame(4)
+	start.IsBlackTurn = false
+	want := []Continuation{{"a1", 3, 2, 1, 0}, {"d1", 1, 0, 1, 0}}
+	if got := currentOpeningBook().Continuations(start); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v from the empty board, got %+v", want, got)
+	}
+
+	// a bot with the book plays the move it's seen often enough, and then searches once it's out of the book
+	bot := &MinimaxBot{MaxDepth: 1, Budget: time.Second, Book: true}
+	if move, err := bot.ChooseMove(start); err != nil || !sameMove(move, Placement{Piece: Piece{Black, Flat}, Coords: "a1"}) {
+		t.Errorf("wanted the bot to open a1 from the book, got %v (%v)", move, err)
+	}
+	afterD1, _ := start.playCopy(Placement{Piece: Piece{Black, Flat}, Coords: "d1"})
+	if move := currentOpeningBook().Move(afterD1); move != nil {
+		t.Errorf("wanted no book move after a game that's only been played once, got %v", move)
+	}
+
+	// and the explorer, over the API
+	playerToken := generateJWT(&mock.takplayer, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+	apiCases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"4", 200, `"games":4,"continuations":[{"move":"a1","games":3,"whiteWins":2,"blackWins":1,"draws":0}`},
+		{"4?moves=a1", 200, `"tps":"x4/x4/x4/2,x3 2 1","positionHash":`},
+		{"4?moves=a1,d4", 200, `"games":3,"continuations":[{"move":"b2","games":2`},
+		{"4?tps=x4/x4/x4/2,x3%202%201", 200, `"continuations":[{"move":"d4","games":3`},
+		{"5", 200, `"games":0,"continuations":[]`},
+		{"4?moves=a1,a1", 400, "problem playing a1"},
+		{"5?tps=x4/x4/x4/x4%201%201", 400, "TPS is for a 4x4 board"},
+	}
+	for _, c := range apiCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/openings/"+c.path, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code || !strings.Contains(string(body), c.body) {
+			t.Errorf("%v: wanted %v containing %v, got %v: %v", c.path, c.code, c.body, resp.StatusCode, string(body))
+		}
+	}
+
+	// one game in the database that won't decode doesn't stop the book being built from the rest
+	dir, _ := ioutil.TempDir("", "gotak-openings")
+	defer os.RemoveAll(dir)
+	sqliteDB, err := InitSQLiteDB(dir + "/openings.db")
+	if err != nil {
+		t.Fatalf("problem setting up database: %v", err)
+	}
+	defer sqliteDB.Close()
+	for i := range mock.games {
+		sqliteDB.StoreTakGame(&mock.games[i])
+	}
+	sqliteDB.Exec("INSERT INTO games(guid, isOver, isPublic, hasStarted, gameBlob) VALUES (?, ?, ?, ?, ?)", uuid.NewV4(), true, true, true, "{not a game")
+	openingBook = NewOpeningBook(nil, bookPlies)
+	if err := (&DBenv{db: sqliteDB}).loadOpeningBook(); err != nil {
+		t.Fatalf("problem building the opening book around a broken game: %v", err)
+	}
+	if got := currentOpeningBook().Continuations(start); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v from the empty board, got %+v", want, got)
+	}
+}
+
+func TestPuzzles(t *testing.T) {
+	// no threats yet, but d1 makes two of them
+	tps := "2,x4/x5/x5/x4,1/1,1,1,x2 1 5"
+	puzzle, err := NewPuzzle(tps, 2)
+	if err != nil {
+		t.Fatalf("problem setting up puzzle: %v", err)
+	}
+	if puzzle.ToMove != White || len(puzzle.Solution) != 3 || puzzle.Solution[0] != "d1" || puzzle.PuzzleID != uuid.NewV5(puzzleNamespace, tps) {
+		t.Errorf("wanted white to road in 2 starting with d1, got %+v", puzzle)
+	}
+
+	badPuzzles := []struct {
+		tps     string
+		depth   int
+		problem string
+	}{
+		{tps, 1, "there's no road to force in 1"},
+		{"2,x4/x5/x5/x5/1,1,1,1,x 1 5", 2, "there's a road in 1"},
+		{tps, 9, "depth must be a number from 1 to 3"},
+		{"2,x4/x5/x5/x5/1,1,1,1,1 2 5", 1, "game is already over"},
+	}
+	for _, c := range badPuzzles {
+		if _, err := NewPuzzle(c.tps, c.depth); err == nil || err.Error() != c.problem {
+			t.Errorf("%v in %v: wanted %v, got %v", c.tps, c.depth, c.problem, err)
+		}
+	}
+
+	// Cd1 isn't the solution, but it makes the same threats; the tinue search has to back it up
+	alternative := puzzle.Attempt("alice", []string{"Cd1"})
+	position, _ := GameFromTPS(tps)
+	position = position.perftCopy()
+	for _, ply := range alternative.Line {
+		move, _ := position.ParsePTNMove(ply)
+		position.ApplyAction(move)
+	}
+	finish := position.RoadWins(White)
+
+	attemptCases := []struct {
+		moves   []string
+		solved  bool
+		problem string
+	}{
+		{[]string{"d1", puzzle.Solution[2]}, true, ""},
+		{[]string{"Cd1", finish[0]}, true, ""},
+		{[]string{"Cd1"}, false, "the road isn't finished yet"},
+		{[]string{"a4"}, false, "a4 lets black off the hook"},
+		{[]string{"a1"}, false, "problem playing a1"},
+		{[]string{"d1", "a4"}, false, "a4 lets black off the hook"},
+	}
+	for _, c := range attemptCases {
+		a := puzzle.Attempt("alice", c.moves)
+		if a.Solved != c.solved || !strings.HasPrefix(a.Problem, c.problem) || a.Username != "alice" {
+			t.Errorf("%v: wanted solved %v with problem '%v', got %v with '%v'", c.moves, c.solved, c.problem, a.Solved, a.Problem)
+		}
+	}
+
+	// the game the puzzle came from: the puzzle is still in there, three plies from the end
+	source, _ := GameFromTPS(tps)
+	source.WhitePlayer, source.BlackPlayer = "alice", "bob"
+	for _, ply := range puzzle.Solution {
+		move, _ := source.ParsePTNMove(ply)
+		source.ApplyAction(move)
+	}
+	unfinished, _ := GameFromTPS(tps)
+	mined := MinePuzzles([]*TakGame{source, unfinished}, 3)
+	if len(mined) != 1 || mined[0].TPS != tps || mined[0].Depth != 2 || mined[0].SourceGame != source.GameID.String() {
+		t.Errorf("wanted to find the puzzle in its game, got %+v", mined)
+	}
+
+	// and over the API
+	mock := &mockDB{takplayer: TakPlayer{Username: "alice"}, games: []TakGame{*source}}
+	mockEnv := DBenv{db: mock}
+	playerToken := generateJWT(&mock.takplayer, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+
+	apiCases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{"GET", "next", "", 404, "no puzzles left to solve"},
+		{"POST", "new", `{"tps": "2,x4/x5/x5/x4,1/1,1,1,x2 1 5", "depth": 2}`, 200, `"solution":["d1",`},
+		{"POST", "new", `{"tps": "2,x4/x5/x5/x4,1/1,1,1,x2 1 5", "depth": 1}`, 422, "not a puzzle: there's no road to force in 1"},
+		// a puzzle 3 moves deep takes too long to check while the request waits: those come from the puzzles command
+		{"POST", "new", `{"tps": "x8/x8/x8/x8/x8/x8/x8/x8 1 1", "depth": 3}`, 400, "depth must be a number from 1 to 2"},
+		{"GET", "next", "", 200, `"tps":"2,x4/x5/x5/x4,1/1,1,1,x2 1 5","toMove":"white","depth":2,"created"`},
+		{"GET", puzzle.PuzzleID.String(), "", 200, `"depth":2,"created"`},
+		{"GET", uuid.NewV5(puzzleNamespace, "nothing").String(), "", 404, "No such puzzle found"},
+		{"POST", puzzle.PuzzleID.String() + "/solve", `{"moves": ["a4"]}`, 200, `"solved":false,"problem":"a4 lets black off the hook"`},
+		{"POST", puzzle.PuzzleID.String() + "/solve", `["d1"]`, 400, "could not understand moves"},
+		{"POST", puzzle.PuzzleID.String() + "/solve", fmt.Sprintf(`{"moves": ["d1", "%v"]}`, puzzle.Solution[2]), 200, `"solved":true,"time":`},
+		{"POST", puzzle.PuzzleID.String() + "/solve", fmt.Sprintf(`{"moves": ["d1", "%v"]}`, puzzle.Solution[2]), 200, `"solution":["d1",`},
+		{"GET", "next", "", 404, "no puzzles left to solve"},
+		{"GET", "history", "", 200, `"moves":["a4"],"line":["a4"],"solved":false`},
+	}
+	for _, c := range apiCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(c.method, "/v1/puzzle/"+c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.code || !strings.Contains(string(body), c.want) {
+			t.Errorf("%v %v: wanted %v containing %v, got %v: %v", c.method, c.path, c.code, c.want, resp.StatusCode, string(body))
+		}
+	}
+	if len(mock.attempts) != 3 || mock.attempts[0].Solved || !mock.attempts[1].Solved {
+		t.Errorf("wanted both attempts recorded, got %+v", mock.attempts)
+	}
+
+	// mining the database finds the puzzle in its game again
+	mock.puzzles = nil
+	if found, err := mockEnv.minePuzzles(2); err != nil || found != 1 || len(mock.puzzles) != 1 || mock.puzzles[0].PuzzleID != puzzle.PuzzleID {
+		t.Errorf("wanted to mine the puzzle from the game, got %v (%v)", found, err)
+	}
+}
+
+func TestBots(t *testing.T) {
+	bot := &MinimaxBot{MaxDepth: 2, Budget: 5 * time.Second}
+
+	// white can win on the spot, and black has to block white's road at e1
+	win, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 1 5")
+	move, err := bot.ChooseMove(win)
+	if next, _ := win.playCopy(move); err != nil || next.RoadWinner() != White {
+		t.Errorf("wanted the bot to take its road win, got %v (%v)", move, err)
+	}
+	block, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 2 5")
+	move, err = bot.ChooseMove(block)
+	if next, _ := block.playCopy(move); err != nil || len(next.RoadWins(White)) > 0 {
+		t.Errorf("wanted the bot to block white's road, got %v (%v)", move, err)
+	}
+
+	// a quick search still finds a move, even on a clock that's nearly run out
+	rushed, _ := MakeGame(5)
+	rushed.IsBlackTurn = false
+	rushed.TimeControl = &TimeControl{Initial: 1}
+	rushed.StartClock()
+	if move, err := (&MinimaxBot{MaxDepth: 5, Budget: time.Hour}).ChooseMove(rushed); move == nil || err != nil {
+		t.Errorf("wanted a move in a hurry, got %v (%v)", move, err)
+	}
+
+	if Evaluate(win, White) <= Evaluate(win, Black) {
+		t.Errorf("wanted white's four flats in a row to score better for white than for black")
+	}
+
+	// over the API: seat a bot, and it answers every move
+	Bots["test"] = &MinimaxBot{MaxDepth: 1, Budget: time.Second}
+	defer delete(Bots, "test")
+	testGame, _ := MakeGame(5)
+	testGame.IsBlackTurn = false
+	testGame.GameOwner, testGame.WhitePlayer = "alice", "alice"
+	mock := &mockDB{takgame: *testGame, takplayer: TakPlayer{Username: "alice"}}
+	mockEnv := DBenv{db: mock}
+	playerToken := generateJWT(&mock.takplayer, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+
+	testCases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		plies  int
+	}{
+		{"GET", "sit?bot=deep-thought", "", 404, 0},
+		{"GET", "sit?bot=test", "", 200, 0},
+		{"GET", "sit?bot=test", "", 409, 0},
+		{"POST", "ptn", "a1", 200, 2},
+		{"POST", "ptn", "c3", 200, 4},
+	}
+	for _, c := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(c.method, fmt.Sprintf("/v1/game/%v/%v", testGame.GameID.String(), c.path), strings.NewReader(c.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		if resp.StatusCode != c.code || mock.takgame.PlyCount() != c.plies {
+			body, _ := ioutil.ReadAll(resp.Body)
+			t.Errorf("%v %v: wanted %v with %v plies played, got %v with %v: %v", c.method, c.path, c.code, c.plies, resp.StatusCode, mock.takgame.PlyCount(), string(body))
+		}
+	}
+	if mock.takgame.BlackPlayer != "bot:test" || mock.takgame.TurnHistory[1].Player != "bot:test" {
+		t.Errorf("wanted the bot to be playing black, got %v", mock.takgame.BlackPlayer)
+	}
+
+	// a bot that fails to move doesn't leave the game stuck on its turn: it gets another go when the game's next
+	// fetched. It grants takebacks, and plays on rather than agree a draw.
+	broken := &brokenBot{broken: true}
+	Bots["broken"] = broken
+	defer delete(Bots, "broken")
+	stuckGame, _ := MakeGame(5)
+	stuckGame.IsBlackTurn = false
+	stuckGame.GameOwner, stuckGame.WhitePlayer, stuckGame.BlackPlayer = "alice", "alice", "bot:broken"
+	stuckGame.HasStarted = true
+	mock.takgame = *stuckGame
+	botCases := []struct {
+		method string
+		path   string
+		body   string
+		fixed  bool
+		code   int
+		plies  int
+	}{
+		{"POST", "ptn", "a1", false, 200, 1},
+		{"GET", "show", "", false, 200, 1},
+		{"GET", "show", "", true, 200, 2},
+		{"POST", "request-takeback", "", true, 200, 0},
+		{"POST", "offer-draw", "", true, 200, 0},
+	}
+	for _, c := range botCases {
+		broken.broken = !c.fixed
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(c.method, fmt.Sprintf("/v1/game/%v/%v", stuckGame.GameID.String(), c.path), strings.NewReader(c.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		if resp.StatusCode != c.code || mock.takgame.PlyCount() != c.plies {
+			body, _ := ioutil.ReadAll(resp.Body)
+			t.Errorf("%v %v with the bot fixed %v: wanted %v with %v plies played, got %v with %v: %v", c.method, c.path, c.fixed, c.code, c.plies, resp.StatusCode, mock.takgame.PlyCount(), string(body))
+		}
+	}
+	if mock.takgame.DrawOffer != "" || mock.takgame.GameOver {
+		t.Errorf("wanted the bot to decline the draw, got offer %q and game over %v", mock.takgame.DrawOffer, mock.takgame.GameOver)
+	}
+
+	// nobody can register as a bot
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/register", strings.NewReader(`{"username": "bot:minimax", "password": "beepboop"}`))
+	genRouter(&mockEnv).ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("wanted registering a bot's name to fail, got %v", rec.Result().StatusCode)
+	}
+}
+
+// brokenBot can't find a move while it's broken, and plays like a quick minimax bot once it's fixed
+type brokenBot struct {
+	broken bool
+}
+
+func (b *brokenBot) ChooseMove(tg *TakGame) (interface{}, error) {
+	if b.broken {
+		return nil, errors.New("out of order")
+	}
+	return (&MinimaxBot{MaxDepth: 1, Budget: time.Second}).ChooseMove(tg)
+}
+
+func TestMCTSBot(t *testing.T) {
+	for _, biased := range []bool{false, true} {
+		bot := &MCTSBot{Playouts: 300, Budget: 10 * time.Second, Biased: biased, Seed: 42}
+		win, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 1 5")
+		move, err := bot.ChooseMove(win)
+		if next, _ := win.playCopy(move); err != nil || next.RoadWinner() != White {
+			t.Errorf("biased %v: wanted the bot to take its road win, got %v (%v)", biased, move, err)
+		}
+		if win.TPS() != "2,x4/x5/x5/x5/1,1,1,1,x 1 5" || win.GameOver {
+			t.Errorf("biased %v: the search changed the game", biased)
+		}
+	}
+
+	// with no road to make, it still has to find a move that stops white's
+	block, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,1,x 2 5")
+	move, err := (&MCTSBot{Playouts: 300, Budget: 10 * time.Second, Seed: 42}).ChooseMove(block)
+	if next, _ := block.playCopy(move); err != nil || len(next.RoadWins(White)) > 0 {
+		t.Errorf("wanted the bot to block white's road, got %v (%v)", move, err)
+	}
+
+	// the same seed makes the same choice
+	opening, _ := MakeGame(4)
+	opening.IsBlackTurn = false
+	first, _ := (&MCTSBot{Playouts: 50, Budget: 10 * time.Second, Seed: 7}).ChooseMove(opening)
+	second, _ := (&MCTSBot{Playouts: 50, Budget: 10 * time.Second, Seed: 7}).ChooseMove(opening)
+	if !sameMove(first, second) {
+		t.Errorf("wanted the same move from the same seed, got %v and %v", first, second)
+	}
+
+	// a new game against a bot seats it straight away, and it makes the first move if it has one
+	Bots["test"] = &MCTSBot{Playouts: 20, Budget: time.Second}
+	defer delete(Bots, "test")
+	alice := TakPlayer{Username: "alice"}
+	mock := &mockDB{takplayer: alice}
+	mockEnv := DBenv{db: mock}
+	playerToken := generateJWT(&alice, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+
+	testCases := []struct {
+		query string
+		code  int
+	}{
+		{"bot=test", 200},
+		{"bot=hal", 400},
+	}
+	for _, c := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/game/new/5?%v", c.query), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		if code := rec.Result().StatusCode; code != c.code {
+			t.Errorf("%v: wanted %v, got %v", c.query, c.code, code)
+		}
+	}
+	g := mock.takgame
+	if g.PlayerColor("alice") == "" || g.PlayerColor("bot:test") == "" || !g.PlayersTurn(&alice) || g.PlyCount() > 1 {
+		t.Errorf("wanted alice and the bot seated, and alice to move, got %v and %v after %v plies", g.WhitePlayer, g.BlackPlayer, g.PlyCount())
+	}
+}
+
+func TestTEI(t *testing.T) {
+	// our own engine, spoken to over TEI
+	script := strings.Join([]string{
+		"tei",
+		"isready",
+		"teinewgame 5",
+		"position tps 2,x4/x5/x5/x5/1,1,1,x2 1 4 moves d1 b5",
+		"go movetime 1000",
+		"quit",
+		"isready",
+	}, "\n")
+	out := &bytes.Buffer{}
+	if err := (&teiCommand{Bot: "minimax-easy"}).serve(strings.NewReader(script), out); err != nil {
+		t.Fatalf("problem serving TEI: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "bestmove ") || lines[len(lines)-2] != "readyok" {
+		t.Fatalf("wanted readyok and then a bestmove, and nothing after quit, got %v", lines)
+	}
+	win, _ := GameFromTPS("2,1,x3/x5/x5/x5/1,1,1,1,x 1 5")
+	move, err := win.ParsePTNMove(strings.Fields(lines[len(lines)-1])[1])
+	if next, _ := win.playCopy(move); err != nil || next.RoadWinner() != White {
+		t.Errorf("wanted the engine to finish white's road, got %v", lines[len(lines)-1])
+	}
+	if err := (&teiCommand{Bot: "hal"}).serve(strings.NewReader(script), out); err == nil {
+		t.Errorf("wanted an error serving an unknown bot")
+	}
+
+	// the positions we send to engines
+	tpsGame, _ := GameFromTPS("2,x4/x5/x5/x5/1,1,1,x2 1 4")
+	tpsGame.WhitePlayer, tpsGame.BlackPlayer = White, Black
+	tpsGame.ApplyAction(Placement{Piece: Piece{White, Flat}, Coords: "d1"})
+	opening, _ := MakeGame(5)
+	opening.IsBlackTurn = false
+	opening.WhitePlayer, opening.BlackPlayer = White, Black
+	opening.ApplyAction(Placement{Piece: Piece{Black, Flat}, Coords: "a1"})
+	blackFirst, _ := MakeGame(4)
+	blackFirst.IsBlackTurn = true
+	positionCases := []struct {
+		game     *TakGame
+		position string
+	}{
+		{opening, "position startpos moves a1"},
+		{tpsGame, "position tps 2,x4/x5/x5/x5/1,1,1,x2 1 4 moves d1"},
+		{blackFirst, "position tps x4/x4/x4/x4 2 1"},
+	}
+	for _, c := range positionCases {
+		if position, err := teiPosition(c.game); err != nil || position != c.position {
+			t.Errorf("wanted %v, got %v (%v)", c.position, position, err)
+		}
+	}
+
+	clocked, _ := MakeGame(5)
+	clocked.TimeControl = &TimeControl{Initial: 600, Increment: 5}
+	clocked.Clock = &GameClock{WhiteRemaining: 300000, BlackRemaining: 200000}
+	if goCommand, thinkingTime := teiGo(clocked, time.Second); goCommand != "go wtime 300000 btime 200000 winc 5000 binc 5000" || thinkingTime != time.Second {
+		t.Errorf("wanted both clocks in the go command and a second to think, got %v and %v", goCommand, thinkingTime)
+	}
+	// with the whole clock to spend, the engine still only gets a twentieth of it to think
+	clocked.IsBlackTurn = false
+	if _, thinkingTime := teiGo(clocked, time.Hour); thinkingTime != 15*time.Second {
+		t.Errorf("wanted white to get a twentieth of their time to think, got %v", thinkingTime)
+	}
+	if budget := teiBudget([]string{"wtime", "300000", "btime", "200000"}, true); budget != 10*time.Second {
+		t.Errorf("wanted black to spend a twentieth of their time, got %v", budget)
+	}
+
+	// an engine running in its own process: this test binary, playing as TestTEIEngineProcess below
+	os.Setenv("GOTAK_TEI_ENGINE", "1")
+	defer os.Unsetenv("GOTAK_TEI_ENGINE")
+	engine := &TEIEngine{Command: os.Args[0], Args: []string{"-test.run=TestTEIEngineProcess"}, MoveTime: time.Second}
+	move, err = engine.ChooseMove(win)
+	if next, _ := win.playCopy(move); err != nil || next.RoadWinner() != White {
+		t.Errorf("wanted the engine to finish white's road, got %v (%v)", move, err)
+	}
+	missing := &TEIEngine{Command: "/no/such/engine"}
+	if _, err := missing.ChooseMove(win); err == nil {
+		t.Errorf("wanted an error from an engine that isn't there")
+	}
+}
+
+// TestTEIEngineProcess is only a test when TestTEI runs the test binary as an engine
+func TestTEIEngineProcess(t *testing.T) {
+	if os.Getenv("GOTAK_TEI_ENGINE") != "1" {
+		return
+	}
+	(&teiCommand{Bot: "minimax-easy"}).serve(os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+func TestTournament(t *testing.T) {
+	configCases := []struct {
+		spec    string
+		want    Bot
+		problem string
+	}{
+		{"minimax-easy", Bots["minimax-easy"], ""},
+		{"minimax-easy:depth=2,roadLine=20,budget=500ms", &MinimaxBot{MaxDepth: 2, Budget: 500 * time.Millisecond, Weights: &EvalWeights{Flat: 100, Wall: 40, Capstone: 60, CapCenter: 10, HardFlat: 20, Captive: 10, RoadLine: 20}}, ""},
+		{"mcts-easy:playouts=20,biased=true,seed=3", &MCTSBot{Playouts: 20, Budget: time.Second, Biased: true, Seed: 3}, ""},
+		{"hal", nil, "no such bot 'hal'"},
+		{"minimax:speed=11", nil, "no such setting 'speed'"},
+		{"minimax:depth=deep", nil, "bad value for depth"},
+		{"minimax:depth", nil, "can't understand setting 'depth'"},
+	}
+	for _, c := range configCases {
+		bot, err := configureBot(c.spec)
+		if c.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), c.problem) {
+				t.Errorf("%v: wanted an error about %v, got %v", c.spec, c.problem, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(bot, c.want) {
+			t.Errorf("%v: wanted %+v, got %+v (%v)", c.spec, c.want, bot, err)
+		}
+	}
+	if Bots["minimax-easy"].(*MinimaxBot).Weights != nil {
+		t.Errorf("configuring a bot changed the one in Bots")
+	}
+
+	eloCases := []struct {
+		result TournamentResult
+		elo    float64
+	}{
+		{TournamentResult{Games: 4, Records: [2]EngineRecord{{Wins: 1}, {Wins: 1}}, Draws: 2}, 0},
+		{TournamentResult{Games: 4, Records: [2]EngineRecord{{Wins: 3}, {Wins: 1}}}, 191},
+		{TournamentResult{Games: 4, Records: [2]EngineRecord{{Wins: 0}, {Wins: 4}}}, -338},
+	}
+	for _, c := range eloCases {
+		if elo, margin := c.result.EloDifference(); math.Abs(elo-c.elo) > 1 || margin <= 0 {
+			t.Errorf("%+v: wanted an Elo difference of %v, got %v ± %v", c.result, c.elo, elo, margin)
+		}
+	}
+
+	// a couple of quick games, written out as PTN that replays to the same results
+	dir, _ := ioutil.TempDir("", "gotak-tournament")
+	defer os.RemoveAll(dir)
+	tc := &tournamentCommand{Size: 3, Games: 2, Engine1: "minimax-easy", Engine2: "mcts-easy:playouts=20", RandomPlies: 2, Seed: 1, Out: dir}
+	out := &bytes.Buffer{}
+	if err := tc.play(out); err != nil {
+		t.Fatalf("problem playing tournament: %v", err)
+	}
+	if !strings.Contains(out.String(), "minimax-easy vs mcts-easy:playouts=20: 2 games on 3x3") || !strings.Contains(out.String(), "Elo difference: ") {
+		t.Errorf("wanted a summary of the tournament, got %v", out.String())
+	}
+	for i, white := range []string{"minimax-easy", "mcts-easy:playouts=20"} {
+		ptn, err := ioutil.ReadFile(fmt.Sprintf("%v/game%03d.ptn", dir, i+1))
+		if err != nil {
+			t.Errorf("game %v: problem reading PTN: %v", i+1, err)
+			continue
+		}
+		pg, _ := ParsePTN(string(ptn))
+		tg, err := pg.Replay()
+		if err != nil || pg.Tag("Player1") != white || pg.Tag("Result") == "" || (tg.ResultCode() != pg.Tag("Result") && pg.Tag("Result") != "1/2-1/2") {
+			t.Errorf("game %v: wanted %v as white and a result that replays, got %v", i+1, white, string(ptn))
+		}
+	}
+
+	// a game that hits the ply cap is an adjudicated draw, not an agreed one, and its PTN says so
+	capped, _ := GameFromTPS("x3/x3/x3 1 1")
+	capped.recordResult("", byMoveLimit)
+	capped.IsGameOver()
+	ptn, _ := capped.PTN()
+	if msg, _ := capped.WhoWins(); !capped.GameOver || capped.AgreedDraw || !capped.Adjudicated || msg != "Draw: the game reached its move limit!" {
+		t.Errorf("wanted an adjudicated draw, got game over %v, agreed %v, adjudicated %v: %v", capped.GameOver, capped.AgreedDraw, capped.Adjudicated, msg)
+	}
+	if !strings.Contains(ptn, "{adjudicated a draw at the move limit, after 0 plies}\n1/2-1/2") {
+		t.Errorf("wanted a comment on the adjudication before the result, got %v", ptn)
+	}
+	if pg, err := ParsePTN(ptn); err != nil || pg.Result != "1/2-1/2" {
+		t.Errorf("wanted the adjudicated PTN to parse as a draw, got %+v (%v)", pg, err)
+	}
+}
+
+func TestLobby(t *testing.T) {
+	// the lobby does its filtering, sorting and paging in SQL, so it's tested against a real database
+	dir, _ := ioutil.TempDir("", "gotak-lobby")
+	defer os.RemoveAll(dir)
+	sqliteDB, err := InitSQLiteDB(dir + "/lobby.db")
+	if err != nil {
+		t.Fatalf("problem setting up database: %v", err)
+	}
+	defer sqliteDB.Close()
+
+	start := time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC)
+	newGame := func(size int, public bool, owner, white, black string, created time.Duration) TakGame {
+		tg, _ := MakeGame(size)
+		tg.IsPublic, tg.GameOwner, tg.WhitePlayer, tg.BlackPlayer = public, owner, white, black
+		tg.Created = start.Add(created)
+		return *tg
+	}
+	waiting := newGame(5, true, "bob", "", "", time.Hour)
+	playing := newGame(5, false, "alice", "alice", "bob", 0)
+	playing.TurnHistory = []MoveRecord{{Type: "place", Coords: "a1", Time: start.Add(3 * time.Hour)}}
+	finished := newGame(6, true, "carol", "carol", "dave", 2*time.Hour)
+	finished.GameOver = true
+	hidden := newGame(5, false, "carol", "carol", "dave", 4*time.Hour)
+	for _, tg := range []TakGame{waiting, playing, finished, hidden} {
+		tg := tg
+		sqliteDB.StoreTakGame(&tg)
+	}
+
+	alice := TakPlayer{Username: "alice", PlayerID: uuid.NewV4()}
+	sqliteDB.StorePlayer(&alice)
+	mockEnv := DBenv{db: sqliteDB}
+	playerToken := generateJWT(&alice, "test")
+	loginResp := TakJWT{}
+	json.Unmarshal(playerToken, &loginResp)
+
+	testCases := []struct {
+		query string
+		code  int
+		games []TakGame
+		total int
+	}{
+		{"", 200, []TakGame{finished, waiting, playing}, 3},
+		{"sort=lastMove", 200, []TakGame{playing, finished, waiting}, 3},
+		{"state=waiting", 200, []TakGame{waiting}, 1},
+		{"state=in-progress", 200, []TakGame{playing}, 1},
+		{"state=finished", 200, []TakGame{finished}, 1},
+		{"size=6", 200, []TakGame{finished}, 1},
+		{"public=true", 200, []TakGame{finished, waiting}, 2},
+		{"player=bob", 200, []TakGame{waiting, playing}, 2},
+		{"player=dave", 200, []TakGame{finished}, 1},
+		{"perPage=2&page=2", 200, []TakGame{playing}, 3},
+		{"page=3&perPage=2", 200, []TakGame{}, 3},
+		{"state=lost", 400, nil, 0},
+		{"sort=name", 400, nil, 0},
+		{"perPage=500", 400, nil, 0},
+		{"page=-1", 400, nil, 0},
+		{"size=big", 400, nil, 0},
+	}
+	for _, c := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/lobby?"+c.query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", loginResp.JWT))
+
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		if resp.StatusCode != c.code {
+			t.Errorf("%v: wanted %v, got %v", c.query, c.code, resp.StatusCode)
+			continue
+		}
+		if c.code != 200 {
+			continue
+		}
+		var list GameList
+		json.NewDecoder(resp.Body).Decode(&list)
+		got, want := []uuid.UUID{}, []uuid.UUID{}
+		for _, g := range list.Games {
+			got = append(got, g.GameID)
+		}
+		for _, g := range c.games {
+			want = append(want, g.GameID)
+		}
+		if !reflect.DeepEqual(got, want) || list.Total != c.total {
+			t.Errorf("%v: wanted %v of %v games, got %v of %v", c.query, want, c.total, got, list.Total)
+		}
+	}
+
+	// a games table from before the lobby gets its new columns filled in from the games already in it
+	oldDB, _ := sql.Open("sqlite3", dir+"/old.db")
+	oldDB.Exec("CREATE TABLE games (guid BLOB(16) PRIMARY KEY UNIQUE, isOver BOOL, isPublic BOOL, hasStarted BOOL, gameBlob VARCHAR)")
+	oldBlob, _ := json.Marshal(waiting)
+	oldDB.Exec("INSERT INTO games(guid, isOver, isPublic, hasStarted, gameBlob) VALUES (?, ?, ?, ?, ?)", waiting.GameID, false, true, false, oldBlob)
+	oldDB.Close()
+	upgraded, err := InitSQLiteDB(dir + "/old.db")
+	if err != nil {
+		t.Fatalf("problem upgrading database: %v", err)
+	}
+	defer upgraded.Close()
+	if games, total, err := upgraded.ListGames(GameFilter{Size: 5, Player: "bob", Sort: SortByCreated, Page: 1, PerPage: 20}, "alice"); err != nil || total != 1 || len(games) != 1 || games[0].GameID != waiting.GameID {
+		t.Errorf("wanted the old game found by its size and owner, got %v of %v (%v)", games, total, err)
+	}
+
+	stateCases := []struct {
+		game  TakGame
+		state string
+	}{
+		{waiting, GameWaiting},
+		{playing, GameInProgress},
+		{finished, GameFinished},
+	}
+	for _, c := range stateCases {
+		if state := c.game.State(); state != c.state {
+			t.Errorf("wanted %v, got %v", c.state, state)
+		}
+	}
+}
+
+func TestPerft(t *testing.T) {
+	testCases := []struct {
+		size  int
+		tps   string
+		depth int
+		nodes int
+	}{
+		{3, "", 1, 9},
+		{3, "", 2, 72},
+		// 7 empty squares, flat or wall, plus every step the white flat can take
+		{3, "", 3, 1200},
+		{5, "", 2, 600},
+		{6, "", 2, 1260},
+		// 12 empty squares for a flat or a wall (no capstones on 4x4), plus 10 ways to move a single stone
+		{4, "1,x3/x4/x4/x,1,1,1 1 4", 1, 34},
+	}
+
+	for _, c := range testCases {
+		tg, _ := MakeGame(c.size)
+		tg.IsBlackTurn = false
+		if c.tps != "" {
+			tg, _ = GameFromTPS(c.tps)
+		}
+		if nodes := tg.Perft(c.depth); nodes != c.nodes {
+			t.Errorf("%v: wanted perft(%v) = %v, got %v", tg.TPS(), c.depth, c.nodes, nodes)
+		}
+		result, err := tg.PerftDivide(c.depth)
+		if err != nil || result.Nodes != c.nodes {
+			t.Errorf("%v: wanted divided perft(%v) = %v, got %v (%v)", tg.TPS(), c.depth, c.nodes, result, err)
+		}
+	}
+
+	// any position where a road move appears should get no further than that move
+	road, _ := GameFromTPS("x4/x4/x4/1,1,1,x 1 4")
+	divide, _ := road.PerftDivide(2)
+	if divide.Divide["d1"] != 0 || divide.Divide["a2"] == 0 {
+		t.Errorf("wanted the road-winning d1 to end the count, got d1: %v, a2: %v", divide.Divide["d1"], divide.Divide["a2"])
+	}
+}
+
+func TestMultiDropMove(t *testing.T) {
+	testGame, _ := MakeGame(5)
+	testGame.GameBoard[1][0] = Stack{[]Piece{whiteFlat, blackFlat, whiteFlat}}
+	testGame.GameBoard[3][4] = Stack{[]Piece{whiteCap, blackFlat, whiteFlat}}
+	testGame.TurnHistory = append(testGame.TurnHistory, MoveRecord{}, MoveRecord{})
+	testGame.IsBlackTurn = false
+	testGame.WhitePlayer = "testWhite"
+	testGame.BlackPlayer = "testBlack"
+
+	if err := testGame.MoveStack(Movement{Coords: "b1", Direction: "+", Carry: 3, Drops: []int{1, 1, 1}}); err != nil {
+		t.Fatalf("problem moving up: %v", err)
+	}
+	testGame.IsBlackTurn = false
+	if err := testGame.MoveStack(Movement{Coords: "d5", Direction: "-", Carry: 3, Drops: []int{2, 1}}); err != nil {
+		t.Fatalf("problem moving down: %v", err)
+	}
+
+	cases := []struct {
+		coords string
+		stack  Stack
+	}{
+		{"b1", Stack{[]Piece{}}},
+		{"b2", Stack{[]Piece{whiteFlat}}},
+		{"b3", Stack{[]Piece{blackFlat}}},
+		{"b4", Stack{[]Piece{whiteFlat}}},
+		{"d5", Stack{[]Piece{}}},
+		{"d4", Stack{[]Piece{blackFlat, whiteFlat}}},
+		{"d3", Stack{[]Piece{whiteCap}}},
+	}
+	for _, c := range cases {
+		if stack, _ := testGame.SquareContents(c.coords); !reflect.DeepEqual(stack, c.stack) {
+			t.Errorf("wanted %v at %v, got %v", c.stack, c.coords, stack)
+		}
+	}
+}
+
+func TestSeeks(t *testing.T) {
+	now := time.Date(2017, 4, 1, 12, 0, 0, 0, time.UTC)
+	clockNow = func() time.Time { return now }
+	defer func() { clockNow = time.Now }()
+
+	mock := &mockDB{players: []string{"alice", "bob", "carol"}}
+	mockEnv := DBenv{db: mock}
+	request := func(user, method, path, body string) *http.Response {
+		mock.takplayer = TakPlayer{Username: user}
+		token := TakJWT{}
+		json.Unmarshal(generateJWT(&mock.takplayer, "test"), &token)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token.JWT))
+		genRouter(&mockEnv).ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	newCases := []struct {
+		user string
+		body string
+		code int
+	}{
+		{"alice", `{"size": 5, "color": "white", "rated": true, "timeControl": {"initial": 600, "increment": 5}}`, 200},
+		{"bob", `{"size": 6, "color": "black", "opponent": "carol", "expiresIn": 60}`, 200},
+		{"alice", `{"size": 4, "expiresIn": 30}`, 200},
+		{"alice", `{"size": 9}`, 400},
+		{"alice", `{"size": 5, "color": "green"}`, 400},
+		{"alice", `{"size": 5, "timeControl": {"increment": 5}}`, 400},
+		{"alice", `{"size": 5, "expiresIn": 1000000}`, 400},
+		{"alice", `{"size": 5, "opponent": "alice"}`, 400},
+		{"alice", `{"size": 5, "opponent": "zed"}`, 404},
+		{"alice", `size 5`, 400},
+	}
+	for _, c := range newCases {
+		if resp := request(c.user, "POST", "/v1/seek/new", c.body); resp.StatusCode != c.code {
+			t.Errorf("%v posting %v: wanted %v, got %v", c.user, c.body, c.code, resp.StatusCode)
+		}
+	}
+	if len(mock.seeks) != 3 {
+		t.Fatalf("wanted 3 seeks stored, got %v", len(mock.seeks))
+	}
+	if mock.seeks[2].Color != SeekRandom {
+		t.Errorf("wanted a seek without a color to be %v, got %v", SeekRandom, mock.seeks[2].Color)
+	}
+	ids := []string{mock.seeks[0].SeekID.String(), mock.seeks[1].SeekID.String(), mock.seeks[2].SeekID.String(), "not-a-uuid"}
+
+	listed := func(user string) []string {
+		seeks := []Seek{}
+		json.NewDecoder(request(user, "GET", "/v1/seek/list", "").Body).Decode(&seeks)
+		found := []string{}
+		for _, s := range seeks {
+			found = append(found, s.SeekID.String())
+		}
+		return found
+	}
+	listCases := []struct {
+		after time.Duration
+		user  string
+		seeks []string
+	}{
+		// the challenge is only shown to the players it's between
+		{0, "alice", []string{ids[0], ids[2]}},
+		{0, "bob", []string{ids[0], ids[1], ids[2]}},
+		{0, "carol", []string{ids[0], ids[1], ids[2]}},
+		// ... and the short seek has expired
+		{45 * time.Second, "carol", []string{ids[0], ids[1]}},
+	}
+	for _, c := range listCases {
+		now = now.Add(c.after)
+		if found := listed(c.user); !reflect.DeepEqual(found, c.seeks) {
+			t.Errorf("wanted %v to see seeks %v, got %v", c.user, c.seeks, found)
+		}
+	}
+	if len(mock.seeks) != 2 {
+		t.Errorf("wanted the expired seek cleared out, got %v seeks", len(mock.seeks))
+	}
+
+	actionCases := []struct {
+		user   string
+		action string
+		seek   int
+		code   int
+	}{
+		{"alice", "accept", 0, 409},
+		{"alice", "accept", 1, 403},
+		{"bob", "accept", 2, 404},
+		{"bob", "accept", 3, 406},
+		{"carol", "cancel", 0, 403},
+		{"bob", "accept", 0, 200},
+		{"carol", "accept", 0, 404},
+		{"alice", "cancel", 1, 403},
+		{"carol", "cancel", 1, 200},
+		{"bob", "cancel", 1, 404},
+	}
+	for _, c := range actionCases {
+		if resp := request(c.user, "POST", fmt.Sprintf("/v1/seek/%v/%v", ids[c.seek], c.action), ""); resp.StatusCode != c.code {
+			t.Errorf("%v trying to %v seek %v: wanted %v, got %v", c.user, c.action, c.seek, c.code, resp.StatusCode)
+		}
+	}
+
+	tg := mock.takgame
+	switch {
+	case tg.WhitePlayer != "alice" || tg.BlackPlayer != "bob" || tg.GameOwner != "alice":
+		t.Errorf("wanted alice as white and owner and bob as black, got %v, %v and owner %v", tg.WhitePlayer, tg.BlackPlayer, tg.GameOwner)
+	case tg.Size != 5 || !tg.Rated || !tg.HasStarted || tg.IsBlackTurn:
+		t.Errorf("wanted a rated 5x5 game started with white to move, got %+v", tg)
+	case tg.TimeControl == nil || tg.Clock == nil || tg.Clock.WhiteBank != 600000:
+		t.Errorf("wanted the seek's clock on the game, got %+v and %+v", tg.TimeControl, tg.Clock)
+	case tg.State() != GameInProgress:
+		t.Errorf("wanted the game %v, got %v", GameInProgress, tg.State())
+	}
+	if len(mock.seeks) != 0 {
+		t.Errorf("wanted no seeks left, got %v", mock.seeks)
+	}
+
+	// a seek that expires before anyone takes it up can't be accepted
+	if resp := request("carol", "POST", "/v1/seek/new", `{"size": 3, "expiresIn": 60}`); resp.StatusCode != 200 {
+		t.Fatalf("wanted a new seek, got %v", resp.StatusCode)
+	}
+	now = now.Add(time.Minute)
+	if resp := request("bob", "POST", fmt.Sprintf("/v1/seek/%v/accept", mock.seeks[0].SeekID), ""); resp.StatusCode != 410 {
+		t.Errorf("wanted an expired seek to be gone, got %v", resp.StatusCode)
+	}
+	if len(mock.seeks) != 0 {
+		t.Errorf("wanted the expired seek cleared out, got %v", mock.seeks)
+	}
+
+	// a player asking for a random color gets one or the other
+	seek := NewSeek("carol", 0)
+	seek.Size = 4
+	seek.Validate()
+	random, _ := seek.StartGame("dave")
+	if !reflect.DeepEqual([]string{random.WhitePlayer, random.BlackPlayer}, []string{"carol", "dave"}) && !reflect.DeepEqual([]string{random.WhitePlayer, random.BlackPlayer}, []string{"dave", "carol"}) {
+		t.Errorf("wanted carol and dave seated, got %v and %v", random.WhitePlayer, random.BlackPlayer)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -62,11 +64,103 @@ func (env *DBenv) NewGame(w http.ResponseWriter, r *http.Request) *WebError {
 		return &WebError{fmt.Errorf("could not create requested board size: %v", err), fmt.Sprintf("could not create requested board: %v", err), http.StatusInternalServerError}
 	}
 
-	// optional URL parameter to indicate the game's open to anyone. Future use, I suspect.
+	// an optional request body can set rule variants, like komi or extra capstones
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(io.LimitReader(r.Body, 1048576)); err != nil {
+			log.Println(err)
+		}
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &newGame.Rules); err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand requested rules: %v", err), http.StatusBadRequest}
+		}
+		if err := newGame.Rules.Validate(boardSize); err != nil {
+			return &WebError{err, fmt.Sprintf("could not use requested rules: %v", err), http.StatusBadRequest}
+		}
+		newGame.UpdateReserves()
+
+		// ... and a time control, alongside the rules
+		var timed struct {
+			TimeControl *TimeControl `json:"timeControl"`
+		}
+		if err := json.Unmarshal(body, &timed); err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand requested time control: %v", err), http.StatusBadRequest}
+		}
+		if timed.TimeControl != nil {
+			if err := timed.TimeControl.Validate(); err != nil {
+				return &WebError{err, fmt.Sprintf("could not use requested time control: %v", err), http.StatusBadRequest}
+			}
+			newGame.TimeControl = timed.TimeControl
+			newGame.StartClock()
+		}
+	}
+
+	// optional URL parameter to indicate the game's open to anyone, and shown to everyone in the lobby
 	isPublic, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("public"))
 
+	// ... and to say it's rated, which rules out analysis and hints while it's being played
+	isRated, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("rated"))
+
 	newGame.GameOwner = player.Username
 	newGame.IsPublic = isPublic
+	newGame.Rated = isRated
+	newGame.Created = time.Now()
+
+	// optional URL parameter to play against a bot: the game's owner and the bot get a seat each, at random
+	if botName := r.FormValue("bot"); botName != "" {
+		if _, ok := Bots[botName]; !ok {
+			return &WebError{fmt.Errorf("no such bot '%v'", botName), fmt.Sprintf("no such bot '%v'", botName), http.StatusBadRequest}
+		}
+		newGame.WhitePlayer, newGame.BlackPlayer = player.Username, BotPlayerName(botName)
+		newGame.HasStarted = true
+		if rand.New(rand.NewSource(time.Now().UnixNano())).Intn(2) == 0 {
+			newGame.WhitePlayer, newGame.BlackPlayer = newGame.BlackPlayer, newGame.WhitePlayer
+		}
+		// if the bot can't make its first move now, it gets another go the next time the game's fetched
+		if err := newGame.PlayBotMoves(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// stash the new game in the db
+	if err := env.db.StoreTakGame(newGame); err != nil {
+		return &WebError{errors.New("problem storing new game"), "problem storing new game", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	gamePayload, _ := json.Marshal(newGame)
+	w.Write([]byte(gamePayload))
+
+	return nil
+}
+
+// NewGameFromTPS sets up a new game at the position described by a TPS string in the request body, so that puzzles and analysis positions can be loaded in one go.
+func (env *DBenv) NewGameFromTPS(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	// read in only up to 1MB of data from the client. Come on, now.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		log.Println(err)
+	}
+
+	newGame, err := GameFromTPS(string(body))
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("could not set up game from TPS: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	isPublic, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("public"))
+	isRated, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("rated"))
+
+	newGame.GameOwner = player.Username
+	newGame.IsPublic = isPublic
+	newGame.Rated = isRated
+	newGame.Created = time.Now()
 	// stash the new game in the db
 	if err := env.db.StoreTakGame(newGame); err != nil {
 		return &WebError{errors.New("problem storing new game"), "problem storing new game", http.StatusInternalServerError}
@@ -97,21 +191,51 @@ func (env *DBenv) ShowGame(w http.ResponseWriter, r *http.Request) *WebError {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "requested game ID '%v' not understood.", gameID)
 	}
-	if requestedGame, err = env.db.RetrieveTakGame(gameID); err != nil {
+	if requestedGame, err = env.fetchGame(gameID); err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "requested game '%v' not found.", gameID)
 	}
 
 	if requestedGame.CanShow(player) {
+		// optional URL parameter to show the game as it stood after an earlier ply, rebuilt from its history
+		var pastGame *TakGame
+		if plyParam := r.FormValue("ply"); plyParam != "" {
+			ply, err := strconv.Atoi(plyParam)
+			if err != nil {
+				return &WebError{err, fmt.Sprintf("could not understand requested ply: %v", plyParam), http.StatusBadRequest}
+			}
+			if pastGame, err = requestedGame.PositionAt(ply); err != nil {
+				return &WebError{err, fmt.Sprintf("could not show game at ply %v: %v", ply, err), http.StatusBadRequest}
+			}
+			requestedGame = pastGame
+		}
 		// optional URL parameter to just show the stack tops.
 		showTops, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("showtops"))
+		// ... or the position as a TPS string
+		if showTPS, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("showtps")); showTPS {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(requestedGame.TPS()))
+			return nil
+		}
+		// ... or just the position's hash, for spotting transpositions
+		if showHash, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("showhash")); showHash {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(requestedGame.PositionHash()))
+			return nil
+		}
 		var gamePayload []byte
+		// games stored before reserves were tracked won't have them filled in
+		requestedGame.UpdateReserves()
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		if showTops {
 			topView := requestedGame.DrawStackTops()
 			gamePayload, _ = json.Marshal(topView)
+		} else if pastGame != nil {
+			gamePayload, _ = json.Marshal(pastGame.PositionView())
 		} else {
 			fmt.Printf("before: %+v\n\n", requestedGame)
 			gamePayload, _ = json.Marshal(requestedGame)
@@ -125,8 +249,8 @@ func (env *DBenv) ShowGame(w http.ResponseWriter, r *http.Request) *WebError {
 	return nil
 }
 
-// Action will accept a JSON action for a particular game, determine whether it's a placement or movement, execute it if rules allow, and then return the updated grid.
-func (env *DBenv) Action(w http.ResponseWriter, r *http.Request) *WebError {
+// LegalMoves lists every move open to the player whose turn it is, so that clients can highlight them
+func (env *DBenv) LegalMoves(w http.ResponseWriter, r *http.Request) *WebError {
 	player, err := env.authUser(r)
 	if err != nil {
 		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
@@ -140,13 +264,569 @@ func (env *DBenv) Action(w http.ResponseWriter, r *http.Request) *WebError {
 	}
 
 	// fetch out and validate that we've got a game by that ID
-	requestedGame, err := env.db.RetrieveTakGame(gameID)
+	requestedGame, err := env.fetchGame(gameID)
 	if err != nil {
 		return &WebError{err, "No such game found", http.StatusNotFound}
 	}
 
-	if !requestedGame.PlayersTurn(player) {
-		return &WebError{errors.New("Not your turn"), "Not this players turn", http.StatusBadRequest}
+	if !requestedGame.CanShow(player) {
+		return &WebError{errors.New("Not allowed to display game"), "Not allowed to display game", http.StatusForbidden}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	movesPayload, _ := json.Marshal(requestedGame.LegalMoveList())
+	w.Write(movesPayload)
+	return nil
+}
+
+// analysisAllowed checks that a player can see analysis of a game, or hints for it. Anyone who can see a finished
+// game can; while it's being played, only its players and owner can, and not at all if it's rated.
+func analysisAllowed(tg *TakGame, player *TakPlayer) *WebError {
+	switch {
+	case !tg.CanShow(player):
+		return &WebError{errors.New("Not allowed to display game"), "Not allowed to display game", http.StatusForbidden}
+	case tg.IsGameOver():
+	case tg.Rated:
+		return &WebError{errors.New("analysis asked for in a rated game"), "no analysis or hints until this rated game is over", http.StatusForbidden}
+	case tg.PlayerColor(player.Username) == "" && tg.GameOwner != player.Username:
+		return &WebError{errors.New("analysis asked for by a spectator"), "only this game's players get analysis or hints until it's over", http.StatusForbidden}
+	}
+	return nil
+}
+
+// AnalyzeGame reports the road threats and forced road wins in a game, as it stands or at an earlier ply. Anyone
+// who can see a finished game can ask; while it's being played, only its players and owner can, and not at all if
+// it's rated.
+func (env *DBenv) AnalyzeGame(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	// get the gameID from the URL path
+	vars := mux.Vars(r)
+	gameID, err := uuid.FromString(vars["gameID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with game ID: %v", err), http.StatusNotAcceptable}
+	}
+
+	// fetch out and validate that we've got a game by that ID
+	requestedGame, err := env.fetchGame(gameID)
+	if err != nil {
+		return &WebError{err, "No such game found", http.StatusNotFound}
+	}
+
+	if webErr := analysisAllowed(requestedGame, player); webErr != nil {
+		return webErr
+	}
+
+	// optional URL parameter to analyze the game as it stood after an earlier ply
+	if plyParam := r.FormValue("ply"); plyParam != "" {
+		ply, err := strconv.Atoi(plyParam)
+		if err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand requested ply: %v", plyParam), http.StatusBadRequest}
+		}
+		if requestedGame, err = requestedGame.PositionAt(ply); err != nil {
+			return &WebError{err, fmt.Sprintf("could not analyze game at ply %v: %v", ply, err), http.StatusBadRequest}
+		}
+	}
+
+	// ... and to search further ahead for tinue
+	depth := 1
+	if depthParam := r.FormValue("depth"); depthParam != "" {
+		if depth, err = strconv.Atoi(depthParam); err != nil || depth < 1 || depth > maxAnalysisDepth {
+			return &WebError{fmt.Errorf("bad depth %v", depthParam), fmt.Sprintf("depth must be a number from 1 to %v", maxAnalysisDepth), http.StatusBadRequest}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	analysisPayload, _ := json.Marshal(requestedGame.Analyze(depth))
+	w.Write(analysisPayload)
+	return nil
+}
+
+// HintMoves suggests the best moves in a game, with the lines the search expects to follow them. Anyone who can see a
+// finished game can ask; while it's being played, only its players and owner can, and not at all if it's rated.
+func (env *DBenv) HintMoves(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	// get the gameID from the URL path
+	vars := mux.Vars(r)
+	gameID, err := uuid.FromString(vars["gameID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with game ID: %v", err), http.StatusNotAcceptable}
+	}
+
+	// fetch out and validate that we've got a game by that ID
+	requestedGame, err := env.fetchGame(gameID)
+	if err != nil {
+		return &WebError{err, "No such game found", http.StatusNotFound}
+	}
+
+	if webErr := analysisAllowed(requestedGame, player); webErr != nil {
+		return webErr
+	}
+
+	// optional URL parameter to look at the game as it stood after an earlier ply
+	if plyParam := r.FormValue("ply"); plyParam != "" {
+		ply, err := strconv.Atoi(plyParam)
+		if err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand requested ply: %v", plyParam), http.StatusBadRequest}
+		}
+		if requestedGame, err = requestedGame.PositionAt(ply); err != nil {
+			return &WebError{err, fmt.Sprintf("could not find hints at ply %v: %v", ply, err), http.StatusBadRequest}
+		}
+	}
+
+	// ... and to ask for more or fewer moves, or a deeper search
+	count, depth := defaultHintCount, defaultHintDepth
+	if countParam := r.FormValue("count"); countParam != "" {
+		if count, err = strconv.Atoi(countParam); err != nil || count < 1 || count > maxHintCount {
+			return &WebError{fmt.Errorf("bad count %v", countParam), fmt.Sprintf("count must be a number from 1 to %v", maxHintCount), http.StatusBadRequest}
+		}
+	}
+	if depthParam := r.FormValue("depth"); depthParam != "" {
+		if depth, err = strconv.Atoi(depthParam); err != nil || depth < 1 || depth > maxHintDepth {
+			return &WebError{fmt.Errorf("bad depth %v", depthParam), fmt.Sprintf("depth must be a number from 1 to %v", maxHintDepth), http.StatusBadRequest}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	hintsPayload, _ := json.Marshal(requestedGame.SuggestMoves(count, depth, hintBudget))
+	w.Write(hintsPayload)
+	return nil
+}
+
+// ExploreOpenings lists the moves played from a position in the games behind the opening book, and how they turned
+// out. The position is given as PTN moves from the empty board, white first, or as a TPS string.
+func (env *DBenv) ExploreOpenings(w http.ResponseWriter, r *http.Request) *WebError {
+	vars := mux.Vars(r)
+	boardSize, err := strconv.Atoi(vars["boardSize"])
+	if err != nil {
+		return &WebError{fmt.Errorf("could not understand requested board size: %v", vars["boardSize"]), fmt.Sprintf("could not understand requested board size: %v", vars["boardSize"]), http.StatusBadRequest}
+	}
+
+	var position *TakGame
+	if tps := r.FormValue("tps"); tps != "" {
+		if position, err = GameFromTPS(tps); err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand TPS: %v", err), http.StatusBadRequest}
+		}
+		if position.Size != boardSize {
+			return &WebError{errors.New("TPS is for the wrong size of board"), fmt.Sprintf("TPS is for a %vx%v board", position.Size, position.Size), http.StatusBadRequest}
+		}
+	} else {
+		if position, err = MakeGame(boardSize); err != nil {
+			return &WebError{err, fmt.Sprintf("could not create requested board: %v", err), http.StatusBadRequest}
+		}
+		position.IsBlackTurn = false
+		position.WhitePlayer, position.BlackPlayer = White, Black
+		// moves can be split up by spaces or commas
+		for _, ply := range strings.FieldsFunc(r.FormValue("moves"), func(c rune) bool { return c == ' ' || c == ',' }) {
+			move, err := position.ParsePTNMove(ply)
+			if err == nil {
+				err = position.ApplyAction(move)
+			}
+			if err != nil {
+				return &WebError{err, fmt.Sprintf("problem playing %v: %v", ply, err), http.StatusBadRequest}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	openingPayload, _ := json.Marshal(currentOpeningBook().Explore(position))
+	w.Write(openingPayload)
+	return nil
+}
+
+// NewPuzzle checks and stores a puzzle sent in as JSON: a TPS position, and the number of moves it takes to force a road from it.
+// Checking a deeper puzzle than analysis goes can take far too long for a request, so those are left to the puzzles command.
+func (env *DBenv) NewPuzzle(w http.ResponseWriter, r *http.Request) *WebError {
+	if _, err := env.authUser(r); err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	var request struct {
+		TPS   string `json:"tps"`
+		Depth int    `json:"depth"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		log.Println(err)
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return &WebError{err, fmt.Sprintf("could not understand puzzle: %v", err), http.StatusBadRequest}
+	}
+	if request.Depth < 1 || request.Depth > maxAnalysisDepth {
+		return &WebError{fmt.Errorf("bad depth %v", request.Depth), fmt.Sprintf("depth must be a number from 1 to %v", maxAnalysisDepth), http.StatusBadRequest}
+	}
+	puzzle, err := NewPuzzle(request.TPS, request.Depth)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("not a puzzle: %v", err), http.StatusUnprocessableEntity}
+	}
+	if err := env.db.StorePuzzle(puzzle); err != nil {
+		return &WebError{errors.New("problem storing puzzle"), "problem storing puzzle", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	puzzlePayload, _ := json.Marshal(puzzle)
+	w.Write(puzzlePayload)
+	return nil
+}
+
+// ShowPuzzle shows a puzzle, without its solution
+func (env *DBenv) ShowPuzzle(w http.ResponseWriter, r *http.Request) *WebError {
+	if _, err := env.authUser(r); err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	vars := mux.Vars(r)
+	puzzleID, err := uuid.FromString(vars["puzzleID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with puzzle ID: %v", err), http.StatusNotAcceptable}
+	}
+	puzzle, err := env.db.RetrievePuzzle(puzzleID)
+	if err != nil {
+		return &WebError{err, "No such puzzle found", http.StatusNotFound}
+	}
+
+	unsolved := *puzzle
+	unsolved.Solution = nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	puzzlePayload, _ := json.Marshal(unsolved)
+	w.Write(puzzlePayload)
+	return nil
+}
+
+// NextPuzzle finds a puzzle the player hasn't solved yet, shortest first, and shows it without its solution
+func (env *DBenv) NextPuzzle(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	puzzles, err := env.db.Puzzles()
+	if err != nil {
+		return &WebError{err, "problem finding puzzles", http.StatusInternalServerError}
+	}
+	attempts, err := env.db.PuzzleAttempts(player.Username)
+	if err != nil {
+		return &WebError{err, "problem finding puzzle history", http.StatusInternalServerError}
+	}
+	solved := map[uuid.UUID]bool{}
+	for _, a := range attempts {
+		solved[a.PuzzleID] = solved[a.PuzzleID] || a.Solved
+	}
+
+	var next *Puzzle
+	for _, p := range puzzles {
+		if !solved[p.PuzzleID] && (next == nil || p.Depth < next.Depth) {
+			next = p
+		}
+	}
+	if next == nil {
+		return &WebError{errors.New("no unsolved puzzles"), "no puzzles left to solve", http.StatusNotFound}
+	}
+
+	unsolved := *next
+	unsolved.Solution = nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	puzzlePayload, _ := json.Marshal(unsolved)
+	w.Write(puzzlePayload)
+	return nil
+}
+
+// SolvePuzzle checks a player's moves, sent in as a JSON list of PTN moves, against a puzzle, and records how they did.
+// The puzzle's solution comes back once it's solved.
+func (env *DBenv) SolvePuzzle(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	vars := mux.Vars(r)
+	puzzleID, err := uuid.FromString(vars["puzzleID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with puzzle ID: %v", err), http.StatusNotAcceptable}
+	}
+	puzzle, err := env.db.RetrievePuzzle(puzzleID)
+	if err != nil {
+		return &WebError{err, "No such puzzle found", http.StatusNotFound}
+	}
+
+	var request struct {
+		Moves []string `json:"moves"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		log.Println(err)
+	}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.Moves) == 0 {
+		return &WebError{fmt.Errorf("bad moves: %v", err), "could not understand moves: send a JSON list of PTN moves", http.StatusBadRequest}
+	}
+
+	attempt := puzzle.Attempt(player.Username, request.Moves)
+	if err := env.db.StorePuzzleAttempt(attempt); err != nil {
+		return &WebError{errors.New("problem storing puzzle attempt"), "problem storing puzzle attempt", http.StatusInternalServerError}
+	}
+
+	result := struct {
+		*PuzzleAttempt
+		Solution []string `json:"solution,omitempty"`
+	}{PuzzleAttempt: attempt}
+	if attempt.Solved {
+		result.Solution = puzzle.Solution
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	attemptPayload, _ := json.Marshal(result)
+	w.Write(attemptPayload)
+	return nil
+}
+
+// PuzzleHistory lists every attempt the player has made at the puzzles, oldest first
+func (env *DBenv) PuzzleHistory(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	attempts, err := env.db.PuzzleAttempts(player.Username)
+	if err != nil {
+		return &WebError{err, "problem finding puzzle history", http.StatusInternalServerError}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	historyPayload, _ := json.Marshal(attempts)
+	w.Write(historyPayload)
+	return nil
+}
+
+// ListGames is the lobby: it lists the games the player can see, a page at a time, picked out by their state, board
+// size, whether they're public and who's playing them
+func (env *DBenv) ListGames(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	publicOnly, _ := regexp.MatchString("^(?i)true|yes$", r.FormValue("public"))
+	filter := GameFilter{
+		State:      r.FormValue("state"),
+		PublicOnly: publicOnly,
+		Player:     r.FormValue("player"),
+		Sort:       r.FormValue("sort"),
+	}
+	for param, value := range map[string]*int{"size": &filter.Size, "page": &filter.Page, "perPage": &filter.PerPage} {
+		if r.FormValue(param) == "" {
+			continue
+		}
+		if *value, err = strconv.Atoi(r.FormValue(param)); err != nil {
+			return &WebError{err, fmt.Sprintf("could not understand %v: %v", param, r.FormValue(param)), http.StatusBadRequest}
+		}
+	}
+	if err := filter.Validate(); err != nil {
+		return &WebError{err, fmt.Sprintf("could not list games: %v", err), http.StatusBadRequest}
+	}
+
+	games, total, err := env.db.ListGames(filter, player.Username)
+	if err != nil {
+		return &WebError{err, "problem finding games", http.StatusInternalServerError}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	listPayload, _ := json.Marshal(newGameList(filter, games, total))
+	w.Write(listPayload)
+	return nil
+}
+
+// NewSeek posts a seek for a game, sent in as JSON: the board size, the color the player wants, whether it's rated
+// and public, any time control and rules, and how many seconds it stays open for. Naming an opponent makes it a
+// challenge to that player alone.
+func (env *DBenv) NewSeek(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	var request struct {
+		Size        int          `json:"size"`
+		Color       string       `json:"color"`
+		Rated       bool         `json:"rated"`
+		IsPublic    bool         `json:"public"`
+		TimeControl *TimeControl `json:"timeControl"`
+		Rules       GameRules    `json:"rules"`
+		Opponent    string       `json:"opponent"`
+		ExpiresIn   int          `json:"expiresIn"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		log.Println(err)
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return &WebError{err, fmt.Sprintf("could not understand seek: %v", err), http.StatusBadRequest}
+	}
+
+	seek := NewSeek(player.Username, time.Duration(request.ExpiresIn)*time.Second)
+	seek.Size = request.Size
+	seek.Color = request.Color
+	seek.Rated = request.Rated
+	seek.IsPublic = request.IsPublic
+	seek.TimeControl = request.TimeControl
+	seek.Rules = request.Rules
+	seek.Opponent = request.Opponent
+	if err := seek.Validate(); err != nil {
+		return &WebError{err, fmt.Sprintf("could not use seek: %v", err), http.StatusBadRequest}
+	}
+	if seek.Opponent != "" && !env.db.PlayerExists(seek.Opponent) {
+		return &WebError{fmt.Errorf("no such player '%v'", seek.Opponent), fmt.Sprintf("no such player '%v'", seek.Opponent), http.StatusNotFound}
+	}
+	if err := env.db.StoreSeek(seek); err != nil {
+		return &WebError{errors.New("problem storing seek"), "problem storing seek", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	seekPayload, _ := json.Marshal(seek)
+	w.Write(seekPayload)
+	return nil
+}
+
+// ListSeeks lists the seeks still open, oldest first: every open seek, and the challenges to or from the player.
+// Seeks that have expired are cleared out along the way.
+func (env *DBenv) ListSeeks(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	seeks, err := env.db.Seeks()
+	if err != nil {
+		return &WebError{err, "problem finding seeks", http.StatusInternalServerError}
+	}
+	open := []*Seek{}
+	for _, seek := range seeks {
+		switch {
+		case seek.Expired():
+			if err := env.db.DeleteSeek(seek.SeekID); err != nil {
+				log.Printf("problem clearing out expired seek %v: %v", seek.SeekID, err)
+			}
+		case seek.VisibleTo(player.Username):
+			open = append(open, seek)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	seeksPayload, _ := json.Marshal(open)
+	w.Write(seeksPayload)
+	return nil
+}
+
+// AcceptSeek takes up a seek, or a challenge to the player, starting the game it asks for with both seats filled
+func (env *DBenv) AcceptSeek(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	vars := mux.Vars(r)
+	seekID, err := uuid.FromString(vars["seekID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with seek ID: %v", err), http.StatusNotAcceptable}
+	}
+	seek, err := env.db.RetrieveSeek(seekID)
+	if err != nil {
+		return &WebError{err, "No such seek found", http.StatusNotFound}
+	}
+
+	switch {
+	case seek.Expired():
+		env.db.DeleteSeek(seekID)
+		return &WebError{errors.New("seek has expired"), "this seek has expired", http.StatusGone}
+	case seek.Player == player.Username:
+		return &WebError{errors.New("own seek"), "can't accept your own seek", http.StatusConflict}
+	case seek.Opponent != "" && seek.Opponent != player.Username:
+		return &WebError{errors.New("not the challenged player"), fmt.Sprintf("this challenge is for %v", seek.Opponent), http.StatusForbidden}
+	}
+
+	newGame, err := seek.StartGame(player.Username)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("could not start game: %v", err), http.StatusInternalServerError}
+	}
+	// whoever deletes the seek gets the game, so two players accepting at once don't both start one
+	if err := env.db.DeleteSeek(seekID); err != nil {
+		return &WebError{err, "this seek has already been taken", http.StatusConflict}
+	}
+	if err := env.db.StoreTakGame(newGame); err != nil {
+		// put the seek back for someone else to try
+		env.db.StoreSeek(seek)
+		return &WebError{errors.New("problem storing new game"), "problem storing new game", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	gamePayload, _ := json.Marshal(newGame)
+	w.Write(gamePayload)
+	return nil
+}
+
+// CancelSeek takes down a seek: the player who posted it can cancel it, and the player it challenges can decline it
+func (env *DBenv) CancelSeek(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	vars := mux.Vars(r)
+	seekID, err := uuid.FromString(vars["seekID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with seek ID: %v", err), http.StatusNotAcceptable}
+	}
+	seek, err := env.db.RetrieveSeek(seekID)
+	if err != nil {
+		return &WebError{err, "No such seek found", http.StatusNotFound}
+	}
+	if seek.Player != player.Username && seek.Opponent != player.Username {
+		return &WebError{errors.New("not allowed to cancel seek"), "only the seek's player can cancel it, or the player it challenges decline it", http.StatusForbidden}
+	}
+	if err := env.db.DeleteSeek(seekID); err != nil {
+		return &WebError{err, "No such seek found", http.StatusNotFound}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	seekPayload, _ := json.Marshal(seek)
+	w.Write(seekPayload)
+	return nil
+}
+
+// Action will accept a JSON action (or a single PTN move) for a particular game, determine whether it's a placement or movement, execute it if rules allow, and then return the updated grid.
+func (env *DBenv) Action(w http.ResponseWriter, r *http.Request) *WebError {
+	player, err := env.authUser(r)
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem authenticating user: %v", err), http.StatusUnprocessableEntity}
+	}
+
+	// get the gameID from the URL path
+	vars := mux.Vars(r)
+	gameID, err := uuid.FromString(vars["gameID"])
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("Problem with game ID: %v", err), http.StatusNotAcceptable}
+	}
+
+	// fetch out and validate that we've got a game by that ID
+	requestedGame, err := env.fetchGame(gameID)
+	if err != nil {
+		return &WebError{err, "No such game found", http.StatusNotFound}
 	}
 
 	// read in only up to 1MB of data from the client. Come on, now.
@@ -155,12 +835,48 @@ func (env *DBenv) Action(w http.ResponseWriter, r *http.Request) *WebError {
 		log.Println(err)
 	}
 
+	// takebacks can be asked for and answered on either player's turn, and so can resignations and draws
+	switch vars["action"] {
+	case "request-takeback", "accept-takeback", "decline-takeback":
+		return env.takebackAction(w, requestedGame, player, vars["action"], body)
+	case "resign", "accept-draw", "decline-draw", "claim-repetition":
+		return env.endingAction(w, requestedGame, player, vars["action"])
+	}
+
+	if !requestedGame.PlayersTurn(player) {
+		return &WebError{errors.New("Not your turn"), "Not this players turn", http.StatusBadRequest}
+	}
+
+	// ... except offering a draw, which a player can only do on their own turn
+	if vars["action"] == "offer-draw" {
+		return env.endingAction(w, requestedGame, player, vars["action"])
+	}
+
 	var (
 		placement Placement
 		movement  Movement
 	)
 
-	if vars["action"] == "place" {
+	// PTN moves can come in on their own action, or as plain text sent to the place or move actions
+	isPTN := vars["action"] == "ptn" || ((vars["action"] == "place" || vars["action"] == "move") && strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain"))
+
+	if isPTN {
+		ply := strings.TrimSpace(string(body))
+		action, parseErr := requestedGame.ParsePTNMove(ply)
+		if parseErr != nil {
+			return &WebError{parseErr, fmt.Sprintf("Problem decoding PTN: %v", parseErr), http.StatusUnprocessableEntity}
+		}
+
+		// Place that Piece or Move that Stack!
+		if actionErr := requestedGame.ApplyAction(action); actionErr != nil {
+			return &WebError{actionErr, fmt.Sprintf("problem playing %v: %v", ply, actionErr), 409}
+		}
+
+		if requestedGame.StartTime.IsZero() {
+			requestedGame.StartTime = time.Now()
+		}
+
+	} else if vars["action"] == "place" {
 		if unmarshalError := json.Unmarshal(body, &placement); unmarshalError != nil {
 			return &WebError{unmarshalError, "Problem decoding JSON", http.StatusUnprocessableEntity}
 		}
@@ -198,15 +914,96 @@ func (env *DBenv) Action(w http.ResponseWriter, r *http.Request) *WebError {
 		if requestedGame.StartTime.IsZero() {
 			requestedGame.StartTime = time.Now()
 		}
+	} else {
+		return &WebError{fmt.Errorf("unknown action '%v'", vars["action"]), fmt.Sprintf("unknown action '%v': try place, move or ptn", vars["action"]), http.StatusNotFound}
+	}
+	requestedGame.PunchClock()
+
+	return env.storeAndShow(w, requestedGame)
+}
+
+// takebackAction asks for a takeback, or answers the one that's been asked for. Asking can take an optional body
+// like {"plies": 2} to say how much to undo.
+func (env *DBenv) takebackAction(w http.ResponseWriter, tg *TakGame, player *TakPlayer, action string, body []byte) *WebError {
+	var err error
+	switch action {
+	case "request-takeback":
+		var request TakebackRequest
+		if len(bytes.TrimSpace(body)) > 0 {
+			if unmarshalError := json.Unmarshal(body, &request); unmarshalError != nil {
+				return &WebError{unmarshalError, "Problem decoding JSON", http.StatusUnprocessableEntity}
+			}
+		}
+		err = tg.RequestTakeback(player.Username, request.Plies)
+	case "accept-takeback":
+		err = tg.AnswerTakeback(player.Username, true)
+	case "decline-takeback":
+		err = tg.AnswerTakeback(player.Username, false)
+	}
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem with takeback: %v", err), http.StatusConflict}
+	}
+	return env.storeAndShow(w, tg)
+}
+
+// endingAction resigns the game, offers, accepts or declines a draw, or claims one by repetition
+func (env *DBenv) endingAction(w http.ResponseWriter, tg *TakGame, player *TakPlayer, action string) *WebError {
+	var err error
+	switch action {
+	case "resign":
+		err = tg.Resign(player.Username)
+	case "offer-draw":
+		err = tg.OfferDraw(player.Username)
+	case "accept-draw":
+		err = tg.AnswerDrawOffer(player.Username, true)
+	case "decline-draw":
+		err = tg.AnswerDrawOffer(player.Username, false)
+	case "claim-repetition":
+		err = tg.ClaimRepetition(player.Username)
+	}
+	if err != nil {
+		return &WebError{err, fmt.Sprintf("problem with %v: %v", action, err), http.StatusConflict}
+	}
+	return env.storeAndShow(w, tg)
+}
+
+// fetchGame gets a game from the DB, checking its clock on the way: a player who's run out of time since the game
+// was last looked at loses it now, and the loss is stored straight away. A bot that couldn't make its move or
+// answer a request when the game was last stored gets another go, so the game doesn't stay stuck waiting on it.
+func (env *DBenv) fetchGame(id uuid.UUID) (*TakGame, error) {
+	tg, err := env.db.RetrieveTakGame(id)
+	if err != nil {
+		return nil, err
+	}
+	changed := tg.UpdateClock()
+	if tg.botPending() {
+		if err := tg.PlayBotMoves(); err != nil {
+			log.Printf("problem playing the bot in game %v: %v", id, err)
+		}
+		changed = true
+	}
+	if changed {
+		if err := env.db.StoreTakGame(tg); err != nil {
+			log.Printf("problem storing game %v: %v", id, err)
+		}
 	}
+	return tg, nil
+}
 
+// storeAndShow saves a game that an action has changed, and sends it back to the client. If the player's playing
+// a bot, the bot replies first, whether that's with a move or an answer to a takeback or draw offer.
+func (env *DBenv) storeAndShow(w http.ResponseWriter, tg *TakGame) *WebError {
+	// a bot that can't reply now gets another go the next time the game's fetched
+	if err := tg.PlayBotMoves(); err != nil {
+		log.Println(err)
+	}
 	// store the updated game back in the DB
-	if err = env.db.StoreTakGame(requestedGame); err != nil {
+	if err := env.db.StoreTakGame(tg); err != nil {
 		return &WebError{err, fmt.Sprintf("storage problem: %v", err), http.StatusInternalServerError}
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	gamePayload, _ := json.Marshal(requestedGame)
+	gamePayload, _ := json.Marshal(tg)
 	w.Write([]byte(gamePayload))
 	return nil
 }
@@ -282,6 +1079,10 @@ func (env *DBenv) Register(w http.ResponseWriter, r *http.Request) *WebError {
 		return &WebError{errors.New("Missing new player username or password"), "Missing new player username or password", http.StatusUnprocessableEntity}
 	}
 
+	if strings.HasPrefix(newPlayer.Username, botPrefix) {
+		return &WebError{fmt.Errorf("new player username %v looks like a bot's", newPlayer.Username), fmt.Sprintf("usernames can't start with '%v'", botPrefix), http.StatusUnprocessableEntity}
+	}
+
 	if env.db.PlayerExists(newPlayer.Username) {
 		return &WebError{fmt.Errorf("new player username %v conflicts with existing username", newPlayer.Username), fmt.Sprintf("new player username '%v' conflicts with existing username", newPlayer.Username), http.StatusUnprocessableEntity}
 	}
@@ -331,29 +1132,50 @@ func (env *DBenv) TakeSeat(w http.ResponseWriter, r *http.Request) *WebError {
 	}
 
 	// fetch out and validate that we've got a game by that ID
-	requestedGame, err := env.db.RetrieveTakGame(gameID)
+	requestedGame, err := env.fetchGame(gameID)
 	if err != nil {
 		return &WebError{err, "No such game found", http.StatusNotFound}
 	}
 
+	// optional URL parameter to seat a bot instead, for the game's owner or one of its players
+	sitter := player.Username
+	if botName := r.FormValue("bot"); botName != "" {
+		if _, ok := Bots[botName]; !ok {
+			return &WebError{fmt.Errorf("no such bot '%v'", botName), fmt.Sprintf("no such bot '%v'", botName), http.StatusNotFound}
+		}
+		if requestedGame.GameOwner != player.Username && requestedGame.PlayerColor(player.Username) == "" {
+			return &WebError{errors.New("not allowed to seat a bot"), "only the game's owner or players can seat a bot", http.StatusForbidden}
+		}
+		if BotFor(requestedGame.WhitePlayer) != nil || BotFor(requestedGame.BlackPlayer) != nil {
+			return &WebError{errors.New("a bot is already seated"), "a game can only have one bot", http.StatusConflict}
+		}
+		sitter = BotPlayerName(botName)
+	}
+
 	switch {
-	case requestedGame.WhitePlayer == player.Username || requestedGame.BlackPlayer == player.Username:
+	case requestedGame.WhitePlayer == sitter || requestedGame.BlackPlayer == sitter:
 		return &WebError{errors.New("already seated at this game"), "already seated at this game", http.StatusConflict} // both seats are open
 	case requestedGame.WhitePlayer == "" && requestedGame.BlackPlayer == "":
 		// flip a coin to see which of the open seats you get.
 		r := rand.New(rand.NewSource(time.Now().UnixNano()))
 		if r.Intn(2) == 0 {
-			requestedGame.BlackPlayer = player.Username
+			requestedGame.BlackPlayer = sitter
 		} else {
-			requestedGame.WhitePlayer = player.Username
+			requestedGame.WhitePlayer = sitter
 		}
 	case requestedGame.WhitePlayer != "" && requestedGame.BlackPlayer != "":
 		// both seats are occupied
 		return &WebError{errors.New("both seats already taken"), "both seats already taken", http.StatusConflict}
 	case requestedGame.BlackPlayer == "":
-		requestedGame.BlackPlayer = player.Username
+		requestedGame.BlackPlayer = sitter
 	case requestedGame.WhitePlayer == "":
-		requestedGame.WhitePlayer = player.Username
+		requestedGame.WhitePlayer = sitter
+	}
+	requestedGame.HasStarted = requestedGame.WhitePlayer != "" && requestedGame.BlackPlayer != ""
+	// a bot with the first move makes it as soon as both seats are filled, or failing that, the next time the
+	// game's fetched
+	if err := requestedGame.PlayBotMoves(); err != nil {
+		log.Println(err)
 	}
 	// store the updated game back in the DB
 	if err = env.db.StoreTakGame(requestedGame); err != nil {
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// the number of moves a hint request suggests by default and at most, how deep it searches by default and at most,
+// and how long it gets to search: on a big board, the deepest search that fits in the time is as deep as it goes
+const (
+	defaultHintCount = 3
+	maxHintCount     = 10
+	defaultHintDepth = 2
+	maxHintDepth     = 3
+	hintBudget       = 2 * time.Second
+)
+
+// Hints suggests the best moves in a position, for players learning what to look for
+type Hints struct {
+	Ply int `json:"ply"`
+	// ToMove is the color of the player the hints are for
+	ToMove string `json:"toMove"`
+	// Depth is how many plies ahead the search looked
+	Depth int `json:"depth"`
+	// Evaluation scores the position as it stands for the player to move, in hundredths of a flat
+	Evaluation int `json:"evaluation"`
+	// Moves are the best moves found, best first
+	Moves []Hint `json:"moves"`
+}
+
+// Hint is one suggested move, with the reason for it: the way the search expects the game to go after it, and how
+// good it thinks the position at the end of that is for the player making the move
+type Hint struct {
+	Move  string `json:"move"`
+	Score int    `json:"score"`
+	// Variation is the move, in PTN, and the best replies to it on both sides
+	Variation []string `json:"variation"`
+	// WinIn or LossIn count the plies to the end of the game, when the search can see that far
+	WinIn  int `json:"winIn,omitempty"`
+	LossIn int `json:"lossIn,omitempty"`
+}
+
+// hintsByScore sorts hints best first
+type hintsByScore []Hint
+
+func (h hintsByScore) Len() int           { return len(h) }
+func (h hintsByScore) Less(i, j int) bool { return h[i].Score > h[j].Score }
+func (h hintsByScore) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// SuggestMoves searches every move in the position and returns the best count of them. Unlike the bots, which only
+// need to know which move is best, it scores the best moves exactly, so they can be compared. It searches a ply
+// deeper at a time up to depth, for as long as the budget allows, and the hints come from the deepest search that
+// finished.
+func (tg *TakGame) SuggestMoves(count, depth int, budget time.Duration) Hints {
+	h := Hints{
+		Ply:        tg.PlyCount(),
+		ToMove:     tg.toMove(),
+		Evaluation: Evaluate(tg, tg.toMove()),
+		Moves:      []Hint{},
+	}
+	if tg.GameOver {
+		h.Depth = depth
+		return h
+	}
+
+	deadline := time.Now().Add(budget)
+	moves := tg.LegalMoves()
+	for d := 1; d <= depth; d++ {
+		hints, ordered, err := searchHints(tg, moves, count, d, deadline, d == 1)
+		if err != nil {
+			break
+		}
+		h.Depth, h.Moves, moves = d, hints, ordered
+	}
+	return h
+}
+
+// searchHints scores moves depth plies deep, and returns the best count of them, best first. It also returns all the
+// moves again with those first, so the next search deeper can cut the rest off sooner. With mustFinish set it
+// ignores the deadline.
+func searchHints(tg *TakGame, moves []interface{}, count, depth int, deadline time.Time, mustFinish bool) ([]Hint, []interface{}, error) {
+	if mustFinish {
+		deadline = time.Time{}
+	}
+
+	hints, best := []Hint{}, []interface{}{}
+	for _, move := range moves {
+		next, err := tg.playCopy(move)
+		if err != nil {
+			continue
+		}
+		// a move only needs an exact score if it could make the best count, so once there are that many, the
+		// search can give up on any move as soon as it's sure it scores no better than the worst of them
+		alpha := -winValue - 1
+		if len(hints) == count {
+			alpha = hints[count-1].Score
+		}
+		score, variation, err := principalVariation(next, depth-1, 1, -winValue-1, -alpha, deadline)
+		if err != nil {
+			return nil, nil, err
+		}
+		if -score <= alpha {
+			continue
+		}
+		hint := Hint{Score: -score, Variation: movesPTN(append([]interface{}{move}, variation...))}
+		hint.Move = hint.Variation[0]
+		switch {
+		case hint.Score > winValue-winHorizon:
+			hint.WinIn = winValue - hint.Score
+		case hint.Score < -winValue+winHorizon:
+			hint.LossIn = winValue + hint.Score
+		}
+
+		// slot it in after any move that scores as well, keeping only the best count
+		i := sort.Search(len(hints), func(i int) bool { return hints[i].Score < hint.Score })
+		hints = append(hints[:i], append([]Hint{hint}, hints[i:]...)...)
+		best = append(best[:i], append([]interface{}{move}, best[i:]...)...)
+		if len(hints) > count {
+			hints, best = hints[:count], best[:count]
+		}
+	}
+
+	ordered := append([]interface{}{}, best...)
+	for _, move := range moves {
+		searched := false
+		for _, b := range best {
+			if sameMove(move, b) {
+				searched = true
+				break
+			}
+		}
+		if !searched {
+			ordered = append(ordered, move)
+		}
+	}
+	return hints, ordered, nil
+}
+
+// winHorizon is further than any search will look, so that scores within it of winValue can only be wins
+const winHorizon = 1000
+
+// principalVariation scores a position for the player to move the same way negamax does, and also returns the moves
+// it expects both players to make from there. A zero deadline means there isn't one.
+func principalVariation(tg *TakGame, depth, ply, alpha, beta int, deadline time.Time) (int, []interface{}, error) {
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return 0, nil, errSearchTimeout
+	}
+	if tg.GameOver {
+		switch tg.GameWinner {
+		case "":
+			return 0, nil, nil
+		case tg.toMove():
+			return winValue - ply, nil, nil
+		}
+		return -winValue + ply, nil, nil
+	}
+	if depth == 0 {
+		return Evaluate(tg, tg.toMove()), nil, nil
+	}
+
+	best, bestLine := -winValue-1, []interface{}(nil)
+	for _, move := range tg.LegalMoves() {
+		next, err := tg.playCopy(move)
+		if err != nil {
+			continue
+		}
+		score, line, err := principalVariation(next, depth-1, ply+1, -beta, -alpha, deadline)
+		if err != nil {
+			return 0, nil, err
+		}
+		score = -score
+		if score > best {
+			best, bestLine = score, append([]interface{}{move}, line...)
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best, bestLine, nil
+}
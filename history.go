@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// the kinds of ply a MoveRecord can describe
+const (
+	PlaceMove string = "place"
+	StackMove string = "move"
+)
+
+// MoveRecord is one ply of a game's TurnHistory. It says what kind of ply it was, so it survives a trip through
+// JSON (and the database) intact, and carries everything needed to play it again.
+type MoveRecord struct {
+	// Type is "place" or "move"
+	Type string `json:"type"`
+	// Player is the username of whoever made the ply, and Color the side they were playing
+	Player string `json:"player,omitempty"`
+	Color  string `json:"color,omitempty"`
+	// Piece is only set for placements; Direction, Carry and Drops only for stack moves
+	Piece     *Piece    `json:"piece,omitempty"`
+	Coords    string    `json:"coords"`
+	Direction string    `json:"direction,omitempty"`
+	Carry     int       `json:"carry,omitempty"`
+	Drops     []int     `json:"drops,omitempty"`
+	Time      time.Time `json:"time"`
+	// PositionHash identifies the position the ply left behind, including whose turn it is next
+	PositionHash string `json:"positionHash,omitempty"`
+}
+
+// NewMoveRecord describes a Placement or Movement as a MoveRecord, without any of the who, when or where details
+func NewMoveRecord(action interface{}) (MoveRecord, error) {
+	switch a := action.(type) {
+	case Placement:
+		piece := a.Piece
+		return MoveRecord{Type: PlaceMove, Piece: &piece, Coords: a.Coords}, nil
+	case Movement:
+		return MoveRecord{Type: StackMove, Coords: a.Coords, Direction: a.Direction, Carry: a.Carry, Drops: a.Drops}, nil
+	case MoveRecord:
+		return a, nil
+	}
+	return MoveRecord{}, fmt.Errorf("unknown action type %T", action)
+}
+
+// Action turns the record back into the Placement or Movement it describes, ready to be played again
+func (mr MoveRecord) Action() (interface{}, error) {
+	switch mr.Type {
+	case PlaceMove:
+		if mr.Piece == nil {
+			return nil, fmt.Errorf("placement at %v doesn't say which piece was placed", mr.Coords)
+		}
+		return Placement{Piece: *mr.Piece, Coords: mr.Coords}, nil
+	case StackMove:
+		return Movement{Coords: mr.Coords, Direction: mr.Direction, Carry: mr.Carry, Drops: mr.Drops}, nil
+	}
+	return nil, fmt.Errorf("unknown move type '%v'", mr.Type)
+}
+
+// UnmarshalJSON reads a MoveRecord, including the bare Placements and Movements that games stored before
+// TurnHistory was typed still hold.
+func (mr *MoveRecord) UnmarshalJSON(data []byte) error {
+	// the alias type has all of MoveRecord's fields but none of its methods, so this doesn't recurse
+	type moveRecord MoveRecord
+	var record moveRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if record.Type == "" {
+		switch {
+		case record.Piece != nil:
+			record.Type = PlaceMove
+		case record.Direction != "":
+			record.Type = StackMove
+		}
+	}
+	*mr = MoveRecord(record)
+	return nil
+}
+
+// recordMove adds a ply that's just been played to the TurnHistory. It runs once the turn indicator has flipped,
+// so the player who made the ply is the one whose turn it isn't.
+func (tg *TakGame) recordMove(action interface{}) {
+	// PlacePiece and MoveStack only ever pass in a Placement or a Movement
+	record, _ := NewMoveRecord(action)
+	record.Color, record.Player = White, tg.WhitePlayer
+	if !tg.IsBlackTurn {
+		record.Color, record.Player = Black, tg.BlackPlayer
+	}
+	record.Time = time.Now()
+	tg.TurnHistory = append(tg.TurnHistory, record)
+	tg.TurnHistory[len(tg.TurnHistory)-1].PositionHash = tg.PositionHash()
+	// any takeback that was being asked for was for a different position, and playing on turns down a draw
+	tg.lapseTakeback()
+	tg.lapseDrawOffer(record.Player)
+}
+
+// PositionView is a snapshot of a game as it stood after a given ply
+type PositionView struct {
+	Ply         int       `json:"ply"`
+	MoveNumber  int       `json:"moveNumber"`
+	IsBlackTurn bool      `json:"isBlackTurn"`
+	GameBoard   GameBoard `json:"gameBoard"`
+	TopView     []string  `json:"topView"`
+	TPS         string    `json:"tps"`
+	// PositionHash identifies the position, whatever order of moves reached it
+	PositionHash string `json:"positionHash"`
+}
+
+// PositionAt rebuilds the game as it stood after the given ply, by playing its TurnHistory over again from the
+// starting position. Plies are counted the same way as PlyCount, so a game set up from TPS can't go back further
+// than its InitialPly. The original game is left untouched.
+func (tg *TakGame) PositionAt(ply int) (*TakGame, error) {
+	if ply < tg.InitialPly || ply > tg.PlyCount() {
+		return nil, fmt.Errorf("ply %v is out of range: this game runs from ply %v to %v", ply, tg.InitialPly, tg.PlyCount())
+	}
+
+	var (
+		past *TakGame
+		err  error
+	)
+	if tg.InitialPosition != "" {
+		if past, err = GameFromTPS(tg.InitialPosition); err != nil {
+			return nil, fmt.Errorf("problem setting up the starting position: %v", err)
+		}
+	} else {
+		if past, err = MakeGame(tg.Size); err != nil {
+			return nil, err
+		}
+		past.IsBlackTurn = tg.blackMovedFirst()
+	}
+	past.GameID = tg.GameID
+	past.GameOwner = tg.GameOwner
+	past.IsPublic = tg.IsPublic
+	past.StartTime = tg.StartTime
+	past.Rules = tg.Rules
+	past.UpdateReserves()
+	// PlacePiece and MoveStack insist on both seats being filled
+	past.WhitePlayer, past.BlackPlayer = White, Black
+
+	history := tg.TurnHistory[:ply-tg.InitialPly]
+	for i, record := range history {
+		if err := past.ApplyAction(record); err != nil {
+			return nil, fmt.Errorf("problem replaying ply %v: %v", tg.InitialPly+i+1, err)
+		}
+	}
+	// keep the original records, with their players and times, rather than the ones made by replaying them
+	past.TurnHistory = append([]MoveRecord(nil), history...)
+	past.WhitePlayer, past.BlackPlayer = tg.WhitePlayer, tg.BlackPlayer
+	past.HasStarted = tg.HasStarted
+	return past, nil
+}
+
+// PositionView sums up the game's current position: the board, a top-down view of it, and whose turn it is
+func (tg *TakGame) PositionView() PositionView {
+	return PositionView{
+		Ply:          tg.PlyCount(),
+		MoveNumber:   tg.MoveNumber(),
+		IsBlackTurn:  tg.IsBlackTurn,
+		GameBoard:    tg.GameBoard,
+		TopView:      tg.DrawStackTops(),
+		TPS:          tg.TPS(),
+		PositionHash: tg.PositionHash(),
+	}
+}
+
+// the kinds of GameEvent a game can record
+const (
+	TakebackRequested string = "takeback-requested"
+	TakebackAccepted  string = "takeback-accepted"
+	TakebackDeclined  string = "takeback-declined"
+	TakebackLapsed    string = "takeback-lapsed"
+	Resigned          string = "resigned"
+	DrawOffered       string = "draw-offered"
+	DrawAccepted      string = "draw-accepted"
+	DrawDeclined      string = "draw-declined"
+	DrawLapsed        string = "draw-lapsed"
+	FlagFell          string = "flag-fell"
+	RepetitionClaimed string = "repetition-claimed"
+)
+
+// GameEvent records something that happened over the course of a game other than a move, like a takeback or a draw offer
+type GameEvent struct {
+	Type string `json:"type"`
+	// Player is the username of whoever caused the event
+	Player string    `json:"player,omitempty"`
+	Time   time.Time `json:"time"`
+	// Plies is the number of plies a takeback asked to undo, and Undone the moves it actually took off the board
+	Plies  int          `json:"plies,omitempty"`
+	Undone []MoveRecord `json:"undone,omitempty"`
+}
+
+// recordEvent adds an event to the game's Events, stamped with the current time
+func (tg *TakGame) recordEvent(event GameEvent) {
+	event.Time = time.Now()
+	tg.Events = append(tg.Events, event)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// the states a game can be in, as the lobby sees them
+const (
+	GameWaiting    = "waiting"
+	GameInProgress = "in-progress"
+	GameFinished   = "finished"
+)
+
+// the orders the lobby can list games in, newest first
+const (
+	SortByCreated  = "created"
+	SortByLastMove = "lastMove"
+)
+
+// the number of games on each page of the lobby, by default and at most
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// GameFilter picks out the games to list in the lobby, and how to list them. The database does the picking, so that
+// a page of the lobby only ever reads in the games on it.
+type GameFilter struct {
+	// State is one of GameWaiting, GameInProgress or GameFinished, or "" for games in any state
+	State string
+	// Size is the board size, or 0 for any size
+	Size int
+	// PublicOnly leaves out private games, even the viewer's own
+	PublicOnly bool
+	// Player only lists games with this player in them, seated or as the owner
+	Player string
+	// Sort is SortByCreated or SortByLastMove
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// Validate checks the filter makes sense, filling in the defaults for anything left out
+func (f *GameFilter) Validate() error {
+	switch f.State {
+	case "", GameWaiting, GameInProgress, GameFinished:
+	default:
+		return fmt.Errorf("unknown state '%v': try %v, %v or %v", f.State, GameWaiting, GameInProgress, GameFinished)
+	}
+	switch f.Sort {
+	case "":
+		f.Sort = SortByCreated
+	case SortByCreated, SortByLastMove:
+	default:
+		return fmt.Errorf("unknown sort '%v': try %v or %v", f.Sort, SortByCreated, SortByLastMove)
+	}
+	if f.Page == 0 {
+		f.Page = 1
+	}
+	if f.PerPage == 0 {
+		f.PerPage = defaultPerPage
+	}
+	switch {
+	case f.Page < 1:
+		return fmt.Errorf("page must be at least 1")
+	case f.PerPage < 1 || f.PerPage > maxPerPage:
+		return fmt.Errorf("perPage must be a number from 1 to %v", maxPerPage)
+	}
+	return nil
+}
+
+// State says whether the game is waiting for players, in progress or finished
+func (tg *TakGame) State() string {
+	switch {
+	case tg.GameOver:
+		return GameFinished
+	case tg.WhitePlayer == "" || tg.BlackPlayer == "":
+		return GameWaiting
+	}
+	return GameInProgress
+}
+
+// LastMoveTime is when the last move in the game was played, or when the game was created if there haven't been any
+func (tg *TakGame) LastMoveTime() time.Time {
+	if len(tg.TurnHistory) == 0 {
+		return tg.Created
+	}
+	return tg.TurnHistory[len(tg.TurnHistory)-1].Time
+}
+
+// GameSummary is a game as the lobby lists it, without the board
+type GameSummary struct {
+	GameID      uuid.UUID    `json:"gameID"`
+	Size        int          `json:"size"`
+	State       string       `json:"state"`
+	WhitePlayer string       `json:"whitePlayer"`
+	BlackPlayer string       `json:"blackPlayer"`
+	GameOwner   string       `json:"gameOwner"`
+	IsPublic    bool         `json:"isPublic"`
+	Rated       bool         `json:"rated"`
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	Ply         int          `json:"ply"`
+	Result      string       `json:"result,omitempty"`
+	Created     time.Time    `json:"created"`
+	LastMove    time.Time    `json:"lastMove"`
+}
+
+// Summary sums up the game for the lobby
+func (tg *TakGame) Summary() GameSummary {
+	return GameSummary{
+		GameID:      tg.GameID,
+		Size:        tg.Size,
+		State:       tg.State(),
+		WhitePlayer: tg.WhitePlayer,
+		BlackPlayer: tg.BlackPlayer,
+		GameOwner:   tg.GameOwner,
+		IsPublic:    tg.IsPublic,
+		Rated:       tg.Rated,
+		TimeControl: tg.TimeControl,
+		Ply:         tg.PlyCount(),
+		Result:      tg.ResultCode(),
+		Created:     tg.Created,
+		LastMove:    tg.LastMoveTime(),
+	}
+}
+
+// GameList is one page of the lobby
+type GameList struct {
+	Games   []GameSummary `json:"games"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"perPage"`
+	// Total counts the games on every page
+	Total int `json:"total"`
+}
+
+// newGameList makes a page of the lobby out of the games the database found for a filter
+func newGameList(f GameFilter, games []*TakGame, total int) GameList {
+	list := GameList{Games: []GameSummary{}, Page: f.Page, PerPage: f.PerPage, Total: total}
+	for _, tg := range games {
+		list.Games = append(list.Games, tg.Summary())
+	}
+	return list
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// MCTSBot picks moves by Monte Carlo tree search: it plays out lots of quick games from the current position, and
+// spends more of its playouts on the moves that win more of them. It stops after Playouts games or once its time
+// Budget runs out, whichever comes first.
+type MCTSBot struct {
+	Playouts int
+	Budget   time.Duration
+	// Biased rollouts prefer flats and capstones over walls and stack moves, which plays out a little more like a
+	// real game than picking uniformly at random
+	Biased bool
+	// Seed makes the bot's choices repeatable; 0 seeds from the clock
+	Seed int64
+	// Book makes the bot play from the opening book while the game's still in it
+	Book bool
+}
+
+// uctExploration balances trying out moves that haven't been played much against replaying the ones that win
+const uctExploration = 1.4
+
+// rolloutTries is how many made-up moves a rollout will try before falling back on the full list of legal moves
+const rolloutTries = 20
+
+// mctsNode is one position in the search tree, reached by playing move
+type mctsNode struct {
+	move     interface{}
+	parent   *mctsNode
+	children []*mctsNode
+	untried  []interface{}
+	// mover is the color that played move, and wins counts the playouts through this node that mover won
+	mover  string
+	wins   float64
+	visits int
+}
+
+// uct scores a child for selection: its win rate, plus a bonus that shrinks the more it's been tried
+func (n *mctsNode) uct() float64 {
+	return n.wins/float64(n.visits) + uctExploration*math.Sqrt(math.Log(float64(n.parent.visits))/float64(n.visits))
+}
+
+// ChooseMove runs playouts from the current position and plays the move that was tried the most. Random games are
+// too noisy to be sure of spotting a road one move away, so a move that makes a road gets played on the spot, and
+// when the opponent is threatening one, only the moves that stop it get searched.
+func (mb *MCTSBot) ChooseMove(tg *TakGame) (interface{}, error) {
+	moves := tg.LegalMoves()
+	if len(moves) == 0 {
+		return nil, errors.New("no legal moves")
+	}
+	if mb.Book {
+		if move := currentOpeningBook().Move(tg); move != nil {
+			return move, nil
+		}
+	}
+	if wins := tg.roadWinningMoves(tg.toMove(), true); len(wins) > 0 {
+		return wins[0], nil
+	}
+	if tg.InTak() {
+		opponent := oppositeColor(tg.toMove())
+		blocks := []interface{}{}
+		for _, move := range moves {
+			next, err := tg.playCopy(move)
+			if err == nil && next.GameWinner != opponent && len(next.roadWinningMoves(opponent, true)) == 0 {
+				blocks = append(blocks, move)
+			}
+		}
+		// with no way out, the search might as well look at everything
+		if len(blocks) > 0 {
+			moves = blocks
+		}
+	}
+	seed := mb.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	// each search gets its own source, since the bots are shared between requests
+	r := rand.New(rand.NewSource(seed))
+	deadline := time.Now().Add(mb.Budget)
+
+	root := &mctsNode{untried: moves, mover: oppositeColor(tg.toMove())}
+	for i := 0; i < mb.Playouts && (i == 0 || time.Now().Before(deadline)); i++ {
+		node, sim := root, tg.perftCopy()
+
+		// selection: follow the best-looking moves down to a node with moves still to try
+		for len(node.untried) == 0 && len(node.children) > 0 {
+			best := node.children[0]
+			for _, child := range node.children[1:] {
+				if child.uct() > best.uct() {
+					best = child
+				}
+			}
+			node = best
+			sim.ApplyAction(node.move)
+		}
+
+		// expansion: add one of those moves to the tree
+		if len(node.untried) > 0 {
+			pick := r.Intn(len(node.untried))
+			move := node.untried[pick]
+			node.untried = append(node.untried[:pick], node.untried[pick+1:]...)
+			mover := sim.toMove()
+			sim.ApplyAction(move)
+			child := &mctsNode{move: move, parent: node, untried: sim.LegalMoves(), mover: mover}
+			node.children = append(node.children, child)
+			node = child
+		}
+
+		// simulation, then backpropagation of whoever won
+		winner := mb.rollout(sim, r)
+		for ; node != nil; node = node.parent {
+			node.visits++
+			switch winner {
+			case node.mover:
+				node.wins++
+			case "":
+				node.wins += 0.5
+			}
+		}
+	}
+
+	best := root.children[0]
+	for _, child := range root.children[1:] {
+		if child.visits > best.visits {
+			best = child
+		}
+	}
+	return best.move, nil
+}
+
+// rollout plays random moves until the game ends, and returns the winning color, or "" for a draw. Games that
+// drag on too long are called for whoever's ahead on the evaluation.
+func (mb *MCTSBot) rollout(sim *TakGame, r *rand.Rand) string {
+	for plies := 0; !sim.GameOver && plies < 2*sim.Size*sim.Size; plies++ {
+		if !mb.playRolloutMove(sim, r) {
+			break
+		}
+	}
+	if sim.GameOver {
+		return sim.GameWinner
+	}
+	switch score := Evaluate(sim, White); {
+	case score > 0:
+		return White
+	case score < 0:
+		return Black
+	}
+	return ""
+}
+
+// playRolloutMove plays a random move in a rollout. Listing every legal move would be most of the cost of a
+// playout, so instead it makes moves up at random and lets PlacePiece and MoveStack turn down the illegal ones,
+// only falling back on the full list if it keeps picking bad ones.
+func (mb *MCTSBot) playRolloutMove(sim *TakGame, r *rand.Rand) bool {
+	color := sim.toMove()
+	orientations := []string{Flat, Wall, Capstone}
+	if mb.Biased {
+		orientations = []string{Flat, Flat, Flat, Wall, Capstone, Capstone, Capstone}
+	}
+
+	for try := 0; try < rolloutTries; try++ {
+		x, y := r.Intn(sim.Size), r.Intn(sim.Size)
+		coords, _ := sim.UnTranslateCoords(x, y)
+		stack := sim.GameBoard[x][y].Pieces
+		var err error
+		switch {
+		case len(stack) == 0 && sim.InOpeningSwap():
+			err = sim.PlacePiece(Placement{Piece: Piece{oppositeColor(color), Flat}, Coords: coords})
+		case len(stack) == 0:
+			err = sim.PlacePiece(Placement{Piece: Piece{color, orientations[r.Intn(len(orientations))]}, Coords: coords})
+		case stack[0].Color == color:
+			carry := len(stack)
+			if carry > sim.CarryLimit() {
+				carry = sim.CarryLimit()
+			}
+			carry = 1 + r.Intn(carry)
+			drops := []int{}
+			for left := carry; left > 0; {
+				drop := 1 + r.Intn(left)
+				drops = append(drops, drop)
+				left -= drop
+			}
+			err = sim.MoveStack(Movement{Coords: coords, Direction: Directions[r.Intn(len(Directions))], Carry: carry, Drops: drops})
+		default:
+			continue
+		}
+		if err == nil {
+			return true
+		}
+	}
+
+	moves := sim.LegalMoves()
+	if len(moves) == 0 {
+		return false
+	}
+	return sim.ApplyAction(mb.pickRolloutMove(moves, r)) == nil
+}
+
+// pickRolloutMove picks a move at random, weighting flats and capstones up if the bot's rollouts are biased
+func (mb *MCTSBot) pickRolloutMove(moves []interface{}, r *rand.Rand) interface{} {
+	if !mb.Biased {
+		return moves[r.Intn(len(moves))]
+	}
+	weights := make([]int, len(moves))
+	total := 0
+	for i, move := range moves {
+		weights[i] = 1
+		if p, ok := move.(Placement); ok && p.Piece.Orientation != Wall {
+			weights[i] = 3
+		}
+		total += weights[i]
+	}
+	n := r.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return moves[i]
+		}
+		n -= w
+	}
+	return moves[len(moves)-1]
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// MinimaxBot searches the game tree with alpha-beta pruning, going one ply deeper at a time until it reaches MaxDepth
+// or runs out of its time Budget, and plays the best move from the deepest search it finished.
+type MinimaxBot struct {
+	MaxDepth int
+	Budget   time.Duration
+	// Book makes the bot play from the opening book while the game's still in it
+	Book bool
+	// Weights overrides DefaultWeights, for trying out new ones
+	Weights *EvalWeights
+}
+
+// EvalWeights are the weights the evaluation gives each feature of a position, in hundredths of a flat
+type EvalWeights struct {
+	Flat      int
+	Wall      int
+	Capstone  int
+	CapCenter int
+	HardFlat  int
+	Captive   int
+	RoadLine  int
+}
+
+// DefaultWeights are the weights the bots play with unless they're given others
+var DefaultWeights = EvalWeights{Flat: 100, Wall: 40, Capstone: 60, CapCenter: 10, HardFlat: 20, Captive: 10, RoadLine: 15}
+
+// winValue is the score for a won game, well beyond anything the evaluation can come up with
+const winValue = 1000000
+
+// clockFraction is the share of its remaining time a bot on a clock will spend on a move: a twentieth
+const clockFraction = 20
+
+// errSearchTimeout stops a search that has run past its deadline
+var errSearchTimeout = errors.New("out of time")
+
+// ChooseMove picks the best move for the player whose turn it is. However short the budget, it always finishes at
+// least a one-ply search, so it always has a move to give.
+func (mb *MinimaxBot) ChooseMove(tg *TakGame) (interface{}, error) {
+	moves := tg.LegalMoves()
+	if len(moves) == 0 {
+		return nil, errors.New("no legal moves")
+	}
+	if mb.Book {
+		if move := currentOpeningBook().Move(tg); move != nil {
+			return move, nil
+		}
+	}
+
+	// on a clock, don't spend more than a small slice of the time that's left
+	budget := mb.Budget
+	if tg.Clock != nil {
+		remaining := tg.Clock.WhiteRemaining
+		if tg.IsBlackTurn {
+			remaining = tg.Clock.BlackRemaining
+		}
+		if slice := time.Duration(remaining/clockFraction) * time.Millisecond; slice < budget {
+			budget = slice
+		}
+	}
+	deadline := time.Now().Add(budget)
+	weights := &DefaultWeights
+	if mb.Weights != nil {
+		weights = mb.Weights
+	}
+
+	best := moves[0]
+	for depth := 1; depth <= mb.MaxDepth; depth++ {
+		move, err := searchRoot(tg, moves, best, depth, deadline, depth == 1, weights)
+		if err != nil {
+			break
+		}
+		best = move
+	}
+	return best, nil
+}
+
+// searchRoot searches each of the moves in the starting position to the given depth, trying the best move from the
+// last search first so that alpha-beta can cut the rest off sooner. With mustFinish set it ignores the deadline.
+func searchRoot(tg *TakGame, moves []interface{}, first interface{}, depth int, deadline time.Time, mustFinish bool, weights *EvalWeights) (interface{}, error) {
+	ordered := []interface{}{first}
+	for _, move := range moves {
+		if !sameMove(move, first) {
+			ordered = append(ordered, move)
+		}
+	}
+	if mustFinish {
+		deadline = time.Time{}
+	}
+
+	best, alpha := first, -winValue-1
+	for _, move := range ordered {
+		next, err := tg.playCopy(move)
+		if err != nil {
+			continue
+		}
+		score, err := negamax(next, depth-1, 1, -winValue-1, -alpha, deadline, weights)
+		if err != nil {
+			return nil, err
+		}
+		if -score > alpha {
+			best, alpha = move, -score
+		}
+	}
+	return best, nil
+}
+
+// negamax scores a position for the player whose turn it is, searching depth plies further. ply counts the plies
+// from the root, so that quicker wins score higher than slower ones. A zero deadline means there isn't one.
+func negamax(tg *TakGame, depth, ply, alpha, beta int, deadline time.Time, weights *EvalWeights) (int, error) {
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return 0, errSearchTimeout
+	}
+	if tg.GameOver {
+		switch tg.GameWinner {
+		case "":
+			return 0, nil
+		case tg.toMove():
+			return winValue - ply, nil
+		}
+		return -winValue + ply, nil
+	}
+	if depth == 0 {
+		return weights.Evaluate(tg, tg.toMove()), nil
+	}
+
+	best := -winValue - 1
+	for _, move := range tg.LegalMoves() {
+		next, err := tg.playCopy(move)
+		if err != nil {
+			continue
+		}
+		score, err := negamax(next, depth-1, ply+1, -beta, -alpha, deadline, weights)
+		if err != nil {
+			return 0, err
+		}
+		score = -score
+		if score > best {
+			best = score
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best, nil
+}
+
+// sameMove compares two moves from the move generator
+func sameMove(a, b interface{}) bool {
+	ptnA, _ := ActionPTN(a)
+	ptnB, _ := ActionPTN(b)
+	return ptnA == ptnB
+}
+
+// Evaluate scores a position from the given color's point of view with the DefaultWeights
+func Evaluate(tg *TakGame, color string) int {
+	return DefaultWeights.Evaluate(tg, color)
+}
+
+// Evaluate scores a position from the given color's point of view, without looking ahead. It counts flats (which
+// decide the game if the board fills up), roads in the making, control of stacks, and capstones near the center.
+func (w *EvalWeights) Evaluate(tg *TakGame, color string) int {
+	score := 0
+	size := len(tg.GameBoard)
+	// rows[c][y] and columns[c][x] count the road pieces each color has in each row and column
+	rows := map[string][]int{color: make([]int, size), oppositeColor(color): make([]int, size)}
+	columns := map[string][]int{color: make([]int, size), oppositeColor(color): make([]int, size)}
+
+	for x := range tg.GameBoard {
+		for y, stack := range tg.GameBoard[x] {
+			if len(stack.Pieces) == 0 {
+				continue
+			}
+			top := stack.Pieces[0]
+			sign := 1
+			if top.Color != color {
+				sign = -1
+			}
+
+			switch top.Orientation {
+			case Flat:
+				score += sign * w.Flat
+			case Wall:
+				score += sign * w.Wall
+			case Capstone:
+				score += sign * (w.Capstone + w.CapCenter*centrality(x, y, size))
+			}
+			if top.Orientation != Wall {
+				rows[top.Color][y]++
+				columns[top.Color][x]++
+			}
+
+			// pieces under a stack belong to whoever's on top: their own are reserves, the others are captives
+			for _, p := range stack.Pieces[1:] {
+				if p.Color == top.Color {
+					score += sign * w.HardFlat
+				} else {
+					score += sign * w.Captive
+				}
+			}
+		}
+	}
+
+	// the more of a line a player holds, the closer they are to a road along it
+	for i := 0; i < size; i++ {
+		for _, lines := range []map[string][]int{rows, columns} {
+			mine, theirs := lines[color][i], lines[oppositeColor(color)][i]
+			score += w.RoadLine * (mine*mine - theirs*theirs)
+		}
+	}
+	return score
+}
+
+// centrality is how close a square is to the middle of the board: 0 in the corners, up to size-1 in the center
+func centrality(x, y, size int) int {
+	distance := abs(2*x-(size-1)) + abs(2*y-(size-1))
+	return (2*(size-1) - distance) / 2
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
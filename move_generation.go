@@ -0,0 +1,119 @@
+package main
+
+// Directions lists the four ways a stack can move, in the order the move generator tries them
+var Directions = []string{"+", "-", "<", ">"}
+
+// LegalMoveList is the JSON presentation of every move open to the player whose turn it is
+type LegalMoveList struct {
+	Placements []Placement `json:"placements"`
+	Movements  []Movement  `json:"movements"`
+	// PTN lists all of the above in Portable Tak Notation, placements first
+	PTN []string `json:"ptn"`
+}
+
+// LegalMoves lists every Placement and Movement the player whose turn it is could legally make.
+// Every candidate is run past ValidatePlacement or ValidateMovement, so this can never disagree with the rules engine.
+func (tg *TakGame) LegalMoves() []interface{} {
+	if tg.IsGameOver() {
+		return nil
+	}
+	moves := []interface{}{}
+
+	color := White
+	if tg.IsBlackTurn {
+		color = Black
+	}
+	pieces := []Piece{{color, Flat}, {color, Wall}, {color, Capstone}}
+	if tg.InOpeningSwap() {
+		// the opening swap: each player's first placement is a flat of the other color, and nothing else is allowed
+		pieces = []Piece{{oppositeColor(color), Flat}}
+	}
+
+	for x := 0; x < tg.Size; x++ {
+		for y := 0; y < tg.Size; y++ {
+			coords, _ := tg.UnTranslateCoords(x, y)
+			if len(tg.GameBoard[x][y].Pieces) == 0 {
+				for _, piece := range pieces {
+					p := Placement{Piece: piece, Coords: coords}
+					if tg.ValidatePlacement(p) == nil {
+						moves = append(moves, p)
+					}
+				}
+				continue
+			}
+			if tg.InOpeningSwap() || tg.GameBoard[x][y].Pieces[0].Color != color {
+				continue
+			}
+			moves = append(moves, tg.legalMovementsFrom(x, y, coords)...)
+		}
+	}
+	return moves
+}
+
+// legalMovementsFrom finds every legal way of moving the stack at x, y: each direction, each carry, each sequence of drops
+func (tg *TakGame) legalMovementsFrom(x, y int, coords string) []interface{} {
+	moves := []interface{}{}
+	maxCarry := len(tg.GameBoard[x][y].Pieces)
+	if maxCarry > tg.CarryLimit() {
+		maxCarry = tg.CarryLimit()
+	}
+
+	for _, direction := range Directions {
+		var room int
+		switch direction {
+		case "+":
+			room = tg.Size - 1 - y
+		case "-":
+			room = y
+		case "<":
+			room = x
+		case ">":
+			room = tg.Size - 1 - x
+		}
+		for carry := 1; carry <= maxCarry; carry++ {
+			for _, drops := range dropSequences(carry, room) {
+				m := Movement{Coords: coords, Direction: direction, Carry: carry, Drops: drops}
+				if tg.ValidateMovement(m) == nil {
+					moves = append(moves, m)
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// dropSequences lists every way of dropping carry pieces, at least one per square, over no more than room squares
+func dropSequences(carry, room int) [][]int {
+	if carry == 0 {
+		return [][]int{{}}
+	}
+	if room == 0 {
+		return nil
+	}
+	sequences := [][]int{}
+	for first := carry; first >= 1; first-- {
+		for _, rest := range dropSequences(carry-first, room-1) {
+			sequences = append(sequences, append([]int{first}, rest...))
+		}
+	}
+	return sequences
+}
+
+// LegalMoveList sorts the game's legal moves into placements and movements, and writes them all out in PTN
+func (tg *TakGame) LegalMoveList() LegalMoveList {
+	list := LegalMoveList{Placements: []Placement{}, Movements: []Movement{}, PTN: []string{}}
+	for _, move := range tg.LegalMoves() {
+		switch m := move.(type) {
+		case Placement:
+			list.Placements = append(list.Placements, m)
+			list.PTN = append(list.PTN, m.PTN())
+		case Movement:
+			list.Movements = append(list.Movements, m)
+		}
+	}
+	// keep the PTN list in the same placements-then-movements order as the JSON
+	for _, m := range list.Movements {
+		list.PTN = append(list.PTN, m.PTN())
+	}
+	return list
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bookPlies is how far into each game the opening book goes
+const bookPlies = 12
+
+// bookMinGames is how many games a move needs behind it before a bot will play it from the book
+const bookMinGames = 3
+
+// OpeningBook gathers up the openings of finished games: for each position, by board size and PositionHash, the
+// moves played from it and how the games they were played in turned out.
+type OpeningBook struct {
+	Plies     int
+	positions map[bookKey]map[string]*Continuation
+}
+
+type bookKey struct {
+	size int
+	hash string
+}
+
+// Continuation is one move played from a position in the book, with the results of the games it was played in
+type Continuation struct {
+	Move      string `json:"move"`
+	Games     int    `json:"games"`
+	WhiteWins int    `json:"whiteWins"`
+	BlackWins int    `json:"blackWins"`
+	Draws     int    `json:"draws"`
+}
+
+// score is how well the move has done for the given color, from 0 for losing every game to 1 for winning them all
+func (c *Continuation) score(color string) float64 {
+	wins := c.WhiteWins
+	if color == Black {
+		wins = c.BlackWins
+	}
+	return (float64(wins) + float64(c.Draws)/2) / float64(c.Games)
+}
+
+// NewOpeningBook builds a book from the first plies of each finished game. Games set up from a TPS position or played
+// without the opening swap don't start the same way as the rest, so they're left out.
+func NewOpeningBook(games []*TakGame, plies int) *OpeningBook {
+	ob := &OpeningBook{Plies: plies, positions: map[bookKey]map[string]*Continuation{}}
+	for _, tg := range games {
+		if !tg.GameOver || tg.InitialPosition != "" || tg.Rules.NoOpeningSwap {
+			continue
+		}
+		history := tg.PositionHistory()
+		for i, record := range tg.TurnHistory {
+			// games stored before positions were hashed have nothing to go on
+			if i >= plies || history[i] == "" {
+				break
+			}
+			move, err := ActionPTN(record)
+			if err != nil {
+				break
+			}
+			ob.add(bookKey{tg.Size, history[i]}, move, tg.GameWinner)
+		}
+	}
+	return ob
+}
+
+func (ob *OpeningBook) add(key bookKey, move, winner string) {
+	if ob.positions[key] == nil {
+		ob.positions[key] = map[string]*Continuation{}
+	}
+	c := ob.positions[key][move]
+	if c == nil {
+		c = &Continuation{Move: move}
+		ob.positions[key][move] = c
+	}
+	c.Games++
+	switch winner {
+	case White:
+		c.WhiteWins++
+	case Black:
+		c.BlackWins++
+	default:
+		c.Draws++
+	}
+}
+
+// Continuations lists the moves the book has for a game's current position, the most played first
+func (ob *OpeningBook) Continuations(tg *TakGame) []Continuation {
+	found := []Continuation{}
+	for _, c := range ob.positions[bookKey{tg.Size, tg.PositionHash()}] {
+		found = append(found, *c)
+	}
+	sort.Sort(continuationsByGames(found))
+	return found
+}
+
+// OpeningPosition is what the opening explorer knows about a position: how many games in the book reached it, and
+// what was played next
+type OpeningPosition struct {
+	Size          int            `json:"size"`
+	TPS           string         `json:"tps"`
+	PositionHash  string         `json:"positionHash"`
+	Games         int            `json:"games"`
+	Continuations []Continuation `json:"continuations"`
+}
+
+// Explore looks up a game's current position in the book
+func (ob *OpeningBook) Explore(tg *TakGame) OpeningPosition {
+	op := OpeningPosition{Size: tg.Size, TPS: tg.TPS(), PositionHash: tg.PositionHash(), Continuations: ob.Continuations(tg)}
+	for _, c := range op.Continuations {
+		op.Games += c.Games
+	}
+	return op
+}
+
+// continuationsByGames sorts continuations by how often they've been played, then by move to keep the order steady
+type continuationsByGames []Continuation
+
+func (c continuationsByGames) Len() int      { return len(c) }
+func (c continuationsByGames) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c continuationsByGames) Less(i, j int) bool {
+	if c[i].Games != c[j].Games {
+		return c[i].Games > c[j].Games
+	}
+	return c[i].Move < c[j].Move
+}
+
+// Move picks a book move for the player to move: whichever has done best for them, out of the moves played in at
+// least bookMinGames games. It returns nil once the game's out of the book.
+func (ob *OpeningBook) Move(tg *TakGame) interface{} {
+	if tg.InitialPosition != "" || tg.Rules.NoOpeningSwap || tg.PlyCount() >= ob.Plies {
+		return nil
+	}
+	color := tg.toMove()
+	var best *Continuation
+	for _, c := range ob.Continuations(tg) {
+		c := c
+		if c.Games >= bookMinGames && (best == nil || c.score(color) > best.score(color)) {
+			best = &c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	move, err := tg.ParsePTNMove(best.Move)
+	if err != nil {
+		return nil
+	}
+	// positions can hash the same by chance, so make sure the move really can be played
+	if _, err := tg.playCopy(move); err != nil {
+		return nil
+	}
+	return move
+}
+
+// the book the bots and the opening explorer use, replaced in one go whenever it's rebuilt
+var (
+	openingBook      = NewOpeningBook(nil, bookPlies)
+	openingBookMutex sync.RWMutex
+)
+
+// currentOpeningBook is the latest opening book built from the database
+func currentOpeningBook() *OpeningBook {
+	openingBookMutex.RLock()
+	defer openingBookMutex.RUnlock()
+	return openingBook
+}
+
+// loadOpeningBook rebuilds the opening book from every finished game in the database
+func (env *DBenv) loadOpeningBook() error {
+	games, err := env.db.FinishedGames()
+	if err != nil {
+		return err
+	}
+	ob := NewOpeningBook(games, bookPlies)
+	openingBookMutex.Lock()
+	openingBook = ob
+	openingBookMutex.Unlock()
+	return nil
+}
+
+// refreshOpeningBook keeps the opening book up to date with the games finished since it was last built
+func (env *DBenv) refreshOpeningBook(every time.Duration) {
+	for {
+		if err := env.loadOpeningBook(); err != nil {
+			log.Printf("problem building opening book: %v", err)
+		}
+		time.Sleep(every)
+	}
+}
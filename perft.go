@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PerftResult holds the node counts for a perft run, broken down by first move
+type PerftResult struct {
+	Depth int `json:"depth"`
+	// Nodes is the total number of move sequences of length Depth
+	Nodes int `json:"nodes"`
+	// Divide maps each legal first move (in PTN) to the number of sequences that start with it
+	Divide map[string]int `json:"divide"`
+}
+
+// Perft counts every legal sequence of moves of the given depth from the current position. Sequences that end
+// the game early don't count, which makes the totals comparable with the perft numbers from other Tak engines.
+func (tg *TakGame) Perft(depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	moves := tg.LegalMoves()
+	if depth == 1 {
+		// no need to play out the last ply just to count it
+		return len(moves)
+	}
+	nodes := 0
+	for _, move := range moves {
+		next := tg.perftCopy()
+		if err := next.ApplyAction(move); err != nil {
+			// LegalMoves has already checked this move against the rules, so this is a bug in one or the other
+			panic(fmt.Sprintf("perft: legal move %v failed in %v: %v", move, tg.TPS(), err))
+		}
+		nodes += next.Perft(depth - 1)
+	}
+	return nodes
+}
+
+// PerftDivide runs Perft for each legal first move separately, which makes it easy to track down exactly where
+// two engines disagree.
+func (tg *TakGame) PerftDivide(depth int) (*PerftResult, error) {
+	if depth < 1 {
+		return nil, errors.New("perft depth must be at least 1")
+	}
+	result := &PerftResult{Depth: depth, Divide: map[string]int{}}
+	for _, move := range tg.LegalMoves() {
+		ptn, _ := ActionPTN(move)
+		next := tg.perftCopy()
+		if err := next.ApplyAction(move); err != nil {
+			return nil, fmt.Errorf("legal move %v failed in %v: %v", ptn, tg.TPS(), err)
+		}
+		result.Divide[ptn] = next.Perft(depth - 1)
+		result.Nodes += result.Divide[ptn]
+	}
+	return result, nil
+}
+
+// perftCopy copies the game, filling in any empty seats, since PlacePiece and MoveStack won't run without them
+func (tg *TakGame) perftCopy() *TakGame {
+	next := tg.Copy()
+	if next.WhitePlayer == "" {
+		next.WhitePlayer = White
+	}
+	if next.BlackPlayer == "" {
+		next.BlackPlayer = Black
+	}
+	return next
+}
+
+// perftCommand runs perft from the command line: gotak perft --size 5 --depth 3 --divide
+type perftCommand struct {
+	Size   int    `long:"size" default:"5" description:"size of the (empty) board to start from"`
+	TPS    string `long:"tps" description:"TPS position to start from, instead of an empty board"`
+	Depth  int    `long:"depth" default:"3" description:"number of plies to count"`
+	Divide bool   `long:"divide" description:"show the node count for each first move"`
+}
+
+// Run works out the starting position and prints the perft counts
+func (pc *perftCommand) Run() error {
+	var (
+		tg  *TakGame
+		err error
+	)
+	if pc.TPS != "" {
+		tg, err = GameFromTPS(pc.TPS)
+	} else {
+		tg, err = MakeGame(pc.Size)
+		if tg != nil {
+			// perft numbers are always counted with white moving first
+			tg.IsBlackTurn = false
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tg.PerftDivide(pc.Depth)
+	if err != nil {
+		return err
+	}
+	if pc.Divide {
+		moves := make([]string, 0, len(result.Divide))
+		for move := range result.Divide {
+			moves = append(moves, move)
+		}
+		sort.Strings(moves)
+		for _, move := range moves {
+			fmt.Printf("%v: %v\n", move, result.Divide[move])
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%v\nperft(%v) = %v\n", tg.TPS(), result.Depth, result.Nodes)
+	return nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PTNTag is a single [Key "Value"] tag pair from the header of a PTN file
+type PTNTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PTNGame is a parsed Portable Tak Notation record: header tags, the plies in order, and the result (if any)
+type PTNGame struct {
+	Tags []PTNTag `json:"tags"`
+	// Plies holds each half-move exactly as written, minus any annotations
+	Plies []string `json:"plies"`
+	// BlackFirst is set when the record opens with "--", i.e. the first player skipped their opening ply
+	BlackFirst bool   `json:"blackFirst"`
+	Result     string `json:"result"`
+}
+
+// PTNResults lists the result tokens allowed at the end of a PTN game
+var PTNResults = map[string]bool{
+	"R-0":     true,
+	"0-R":     true,
+	"F-0":     true,
+	"0-F":     true,
+	"1-0":     true,
+	"0-1":     true,
+	"1/2-1/2": true,
+	"0-0":     true,
+}
+
+var (
+	ptnTagRegexp       = regexp.MustCompile(`^\[\s*([A-Za-z0-9_]+)\s+"([^"]*)"\s*\]$`)
+	ptnCommentRegexp   = regexp.MustCompile(`(?s)\{.*?\}`)
+	ptnMoveNumRegexp   = regexp.MustCompile(`^(\d+)\.(.*)$`)
+	ptnPlacementRegexp = regexp.MustCompile(`^([FSC]?)([a-h])([1-8])$`)
+	ptnMovementRegexp  = regexp.MustCompile(`^([1-8]?)([a-h])([1-8])([<>+-])([1-8]*)(\*?)$`)
+	ptnAnnotation      = regexp.MustCompile(`['"!?]+$`)
+	ptnSquareRegexp    = regexp.MustCompile(`[a-h][1-8]`)
+	ptnCarryRegexp     = regexp.MustCompile(`^[1-8]$`)
+)
+
+// ParsePTN reads a complete PTN game record into its tags, plies and result
+func ParsePTN(ptn string) (*PTNGame, error) {
+	pg := &PTNGame{}
+	var body []string
+
+	// comments can span lines, so strip them out before looking at anything else
+	ptn = ptnCommentRegexp.ReplaceAllString(ptn, " ")
+
+	for _, line := range strings.Split(ptn, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			tag := ptnTagRegexp.FindStringSubmatch(line)
+			if tag == nil {
+				return nil, fmt.Errorf("could not parse PTN tag '%v'", line)
+			}
+			pg.Tags = append(pg.Tags, PTNTag{Key: tag[1], Value: tag[2]})
+			continue
+		}
+		body = append(body, strings.Fields(line)...)
+	}
+
+	for _, token := range body {
+		// move numbers may or may not have whitespace between them and the first ply: "1. a1" or "1.a1"
+		if num := ptnMoveNumRegexp.FindStringSubmatch(token); num != nil {
+			if token = num[2]; token == "" {
+				continue
+			}
+		}
+		switch {
+		case pg.Result != "":
+			return nil, fmt.Errorf("unexpected '%v' after game result %v", token, pg.Result)
+		case PTNResults[token]:
+			pg.Result = token
+		case token == "--":
+			if len(pg.Plies) > 0 || pg.BlackFirst {
+				return nil, errors.New("'--' is only allowed as the very first ply")
+			}
+			pg.BlackFirst = true
+		default:
+			ply := ptnAnnotation.ReplaceAllString(token, "")
+			if !ptnPlacementRegexp.MatchString(ply) && !ptnMovementRegexp.MatchString(ply) {
+				return nil, ptnSyntaxError(token)
+			}
+			pg.Plies = append(pg.Plies, ply)
+		}
+	}
+	return pg, nil
+}
+
+// Tag returns the value of the named tag, or "" if the record doesn't have it
+func (pg *PTNGame) Tag(key string) string {
+	for _, t := range pg.Tags {
+		if strings.EqualFold(t.Key, key) {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// Replay builds a new TakGame from the record by running every ply through PlacePiece or MoveStack.
+// The resulting game's TurnHistory holds a MoveRecord for each ply.
+func (pg *PTNGame) Replay() (*TakGame, error) {
+	var (
+		tg  *TakGame
+		err error
+	)
+	if tps := pg.Tag("TPS"); tps != "" {
+		// the game picks up from a position, rather than an empty board
+		if tg, err = GameFromTPS(tps); err != nil {
+			return nil, err
+		}
+	} else {
+		size, sizeErr := strconv.Atoi(pg.Tag("Size"))
+		if sizeErr != nil {
+			return nil, fmt.Errorf("PTN record has no usable Size tag: '%v'", pg.Tag("Size"))
+		}
+		if tg, err = MakeGame(size); err != nil {
+			return nil, err
+		}
+		tg.IsBlackTurn = pg.BlackFirst
+	}
+	if tg.Rules, err = pg.Rules(tg.Size); err != nil {
+		return nil, err
+	}
+	tg.UpdateReserves()
+	tg.WhitePlayer = pg.Tag("Player1")
+	tg.BlackPlayer = pg.Tag("Player2")
+	// PlacePiece and MoveStack insist on both seats being filled
+	if tg.WhitePlayer == "" {
+		tg.WhitePlayer = White
+	}
+	if tg.BlackPlayer == "" {
+		tg.BlackPlayer = Black
+	}
+
+	for i, ply := range pg.Plies {
+		action, err := tg.ParsePTNMove(ply)
+		if err != nil {
+			return nil, fmt.Errorf("ply %v (%v): %v", i+1, ply, err)
+		}
+		if err := tg.ApplyAction(action); err != nil {
+			return nil, fmt.Errorf("ply %v (%v): %v", i+1, ply, err)
+		}
+	}
+	return tg, nil
+}
+
+// Rules reads any rule variants out of the record's Komi, Flats, Caps and Opening tags
+func (pg *PTNGame) Rules(size int) (GameRules, error) {
+	var rules GameRules
+	if komi := pg.Tag("Komi"); komi != "" {
+		flats, err := strconv.ParseFloat(komi, 64)
+		if err != nil || flats*2 != float64(int(flats*2)) {
+			return rules, fmt.Errorf("could not understand Komi tag '%v': expected a whole or half number of flats", komi)
+		}
+		rules.HalfKomi = int(flats * 2)
+	}
+	if stones := pg.Tag("Flats"); stones != "" {
+		n, err := strconv.Atoi(stones)
+		if err != nil {
+			return rules, fmt.Errorf("could not understand Flats tag '%v'", stones)
+		}
+		rules.Stones = &n
+	}
+	if caps := pg.Tag("Caps"); caps != "" {
+		n, err := strconv.Atoi(caps)
+		if err != nil {
+			return rules, fmt.Errorf("could not understand Caps tag '%v'", caps)
+		}
+		rules.Capstones = &n
+	}
+	rules.NoOpeningSwap = pg.Tag("Opening") == "no-swap"
+	return rules, rules.Validate(size)
+}
+
+// ParsePTNMove translates a single PTN ply into a Placement or Movement for the game's current position.
+// PTN doesn't record piece colors, so they're worked out from whose turn it is (and the opening swap).
+func (tg *TakGame) ParsePTNMove(ply string) (interface{}, error) {
+	ply = ptnAnnotation.ReplaceAllString(strings.TrimSpace(ply), "")
+
+	if p := ptnPlacementRegexp.FindStringSubmatch(ply); p != nil {
+		color := White
+		if tg.IsBlackTurn {
+			color = Black
+		}
+		// the very first two placements are of the opponent's color
+		if tg.InOpeningSwap() {
+			color = oppositeColor(color)
+		}
+		orientation := Flat
+		switch p[1] {
+		case "S":
+			orientation = Wall
+		case "C":
+			orientation = Capstone
+		}
+		return Placement{Piece: Piece{Color: color, Orientation: orientation}, Coords: p[2] + p[3]}, nil
+	}
+
+	m := ptnMovementRegexp.FindStringSubmatch(ply)
+	if m == nil {
+		return nil, ptnSyntaxError(ply)
+	}
+	carry := 1
+	if m[1] != "" {
+		carry, _ = strconv.Atoi(m[1])
+	}
+	// no drop counts means the whole carried stack lands on the next square
+	drops := []int{carry}
+	if m[5] != "" {
+		drops = make([]int, len(m[5]))
+		total := 0
+		for i, d := range m[5] {
+			drops[i] = int(d - '0')
+			total += drops[i]
+		}
+		if total != carry {
+			return nil, fmt.Errorf("drops in '%v' add up to %v, but %v pieces are carried", ply, total, carry)
+		}
+	}
+	return Movement{Coords: m[2] + m[3], Direction: m[4], Carry: carry, Drops: drops}, nil
+}
+
+// ptnSyntaxError works out what, exactly, is wrong with a ply that didn't parse
+func ptnSyntaxError(ply string) error {
+	if ply == "" {
+		return errors.New("empty PTN move")
+	}
+	square := ptnSquareRegexp.FindStringIndex(ply)
+	if square == nil {
+		return fmt.Errorf("could not parse PTN move '%v': no square between a1 and h8", ply)
+	}
+	prefix, rest := ply[:square[0]], ply[square[1]:]
+	switch {
+	case rest == "" && ptnCarryRegexp.MatchString(prefix):
+		return fmt.Errorf("could not parse PTN move '%v': movement has no direction", ply)
+	case rest == "":
+		return fmt.Errorf("could not parse PTN move '%v': unknown piece type '%v', use F, S or C", ply, prefix)
+	case prefix != "" && !ptnCarryRegexp.MatchString(prefix):
+		return fmt.Errorf("could not parse PTN move '%v': carry count '%v' must be 1 to 8", ply, prefix)
+	case !strings.ContainsAny(rest[:1], "<>+-"):
+		return fmt.Errorf("could not parse PTN move '%v': unknown direction '%v', use <, >, + or -", ply, rest[:1])
+	}
+	return fmt.Errorf("could not parse PTN move '%v': drop counts '%v' must be digits 1 to 8", ply, rest[1:])
+}
+
+// ApplyAction hands a Placement or Movement (or a MoveRecord of one) to PlacePiece or MoveStack as appropriate
+func (tg *TakGame) ApplyAction(action interface{}) error {
+	switch a := action.(type) {
+	case Placement:
+		return tg.PlacePiece(a)
+	case Movement:
+		return tg.MoveStack(a)
+	case MoveRecord:
+		recorded, err := a.Action()
+		if err != nil {
+			return err
+		}
+		return tg.ApplyAction(recorded)
+	}
+	return fmt.Errorf("unknown action type %T", action)
+}
+
+// PTN renders a placement in Portable Tak Notation, e.g. "a1", "Sb3" or "Cc4"
+func (p Placement) PTN() string {
+	switch strings.ToLower(p.Piece.Orientation) {
+	case Wall:
+		return "S" + strings.ToLower(p.Coords)
+	case Capstone:
+		return "C" + strings.ToLower(p.Coords)
+	}
+	return strings.ToLower(p.Coords)
+}
+
+// PTN renders a movement in Portable Tak Notation, e.g. "a1>", "3c3>12"
+func (m Movement) PTN() string {
+	ptn := strings.ToLower(m.Coords) + m.Direction
+	if m.Carry != 1 {
+		ptn = strconv.Itoa(m.Carry) + ptn
+	}
+	// the drop counts are only spelled out when the stack doesn't all land on one square
+	if len(m.Drops) > 1 || (len(m.Drops) == 1 && m.Drops[0] != m.Carry) {
+		for _, d := range m.Drops {
+			ptn += strconv.Itoa(d)
+		}
+	}
+	return ptn
+}
+
+// ActionPTN renders a Placement, Movement or MoveRecord in Portable Tak Notation
+func ActionPTN(action interface{}) (string, error) {
+	switch a := action.(type) {
+	case Placement:
+		return a.PTN(), nil
+	case Movement:
+		return a.PTN(), nil
+	case MoveRecord:
+		recorded, err := a.Action()
+		if err != nil {
+			return "", err
+		}
+		return ActionPTN(recorded)
+	}
+	return "", fmt.Errorf("unknown action type %T", action)
+}
+
+// ResultCode gives the PTN result token for a finished game, or "" if it's still in progress
+func (tg *TakGame) ResultCode() string {
+	switch {
+	case tg.WhiteWinner && tg.RoadWin:
+		return "R-0"
+	case tg.BlackWinner && tg.RoadWin:
+		return "0-R"
+	case tg.WhiteWinner && (tg.Resignation || tg.TimeForfeit):
+		return "1-0"
+	case tg.BlackWinner && (tg.Resignation || tg.TimeForfeit):
+		return "0-1"
+	case tg.WhiteWinner:
+		return "F-0"
+	case tg.BlackWinner:
+		return "0-F"
+	case tg.DrawGame:
+		return "1/2-1/2"
+	}
+	return ""
+}
+
+// PTN serializes the whole game, including its TurnHistory, as a Portable Tak Notation record
+func (tg *TakGame) PTN() (string, error) {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "[Site \"gotak\"]\n")
+	fmt.Fprintf(&b, "[Player1 \"%v\"]\n", tg.WhitePlayer)
+	fmt.Fprintf(&b, "[Player2 \"%v\"]\n", tg.BlackPlayer)
+	if !tg.StartTime.IsZero() {
+		fmt.Fprintf(&b, "[Date \"%v\"]\n", tg.StartTime.Format("2006.01.02"))
+	}
+	fmt.Fprintf(&b, "[Size \"%v\"]\n", tg.Size)
+	if tg.InitialPosition != "" {
+		fmt.Fprintf(&b, "[TPS \"%v\"]\n", tg.InitialPosition)
+	}
+	if tg.Rules.HalfKomi > 0 {
+		fmt.Fprintf(&b, "[Komi \"%v\"]\n", tg.Rules.Komi())
+	}
+	if tg.Rules.Stones != nil {
+		fmt.Fprintf(&b, "[Flats \"%v\"]\n", *tg.Rules.Stones)
+	}
+	if tg.Rules.Capstones != nil {
+		fmt.Fprintf(&b, "[Caps \"%v\"]\n", *tg.Rules.Capstones)
+	}
+	if tg.Rules.NoOpeningSwap {
+		fmt.Fprintf(&b, "[Opening \"no-swap\"]\n")
+	}
+	result := tg.ResultCode()
+	if result != "" {
+		fmt.Fprintf(&b, "[Result \"%v\"]\n", result)
+	}
+	b.WriteString("\n")
+
+	// number the plies the way PTN does, with the first player's ply always on the left
+	firstPly := tg.InitialPly
+	if tg.blackMovedFirst() {
+		firstPly++
+	}
+	plies := make([]string, 0, len(tg.TurnHistory)+1)
+	if firstPly%2 == 1 {
+		plies = append(plies, "--")
+		firstPly--
+	}
+	for _, action := range tg.TurnHistory {
+		ply, err := ActionPTN(action)
+		if err != nil {
+			return "", err
+		}
+		plies = append(plies, ply)
+	}
+
+	for i := 0; i < len(plies); i += 2 {
+		fmt.Fprintf(&b, "%v. %v", (firstPly+i)/2+1, plies[i])
+		if i+1 < len(plies) {
+			fmt.Fprintf(&b, " %v", plies[i+1])
+		}
+		b.WriteString("\n")
+	}
+	if tg.Adjudicated {
+		fmt.Fprintf(&b, "{adjudicated a draw at the move limit, after %v plies}\n", tg.PlyCount())
+	}
+	if result != "" {
+		b.WriteString(result + "\n")
+	}
+	return b.String(), nil
+}
+
+// oppositeColor returns the other player's color
+func oppositeColor(color string) string {
+	if color == Black {
+		return White
+	}
+	return Black
+}
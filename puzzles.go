@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxPuzzleDepth caps how many moves a puzzle's road can take: checking a move that isn't in the solution means a
+// tinue search one move shallower, which is as deep as analysis goes
+const maxPuzzleDepth = maxAnalysisDepth + 1
+
+// Puzzle is a position where the player to move can force a road: "white to move and win in 2"
+type Puzzle struct {
+	PuzzleID uuid.UUID `json:"puzzleID"`
+	TPS      string    `json:"tps"`
+	// ToMove is the color of the player solving the puzzle
+	ToMove string `json:"toMove"`
+	// Depth is how many of their own moves it takes the solver to finish the road
+	Depth int `json:"depth"`
+	// Solution is one way to solve it, in PTN: the solver's moves, with the defender's replies in between
+	Solution []string `json:"solution,omitempty"`
+	// SourceGame is the game the puzzle was found in, if it was found in one
+	SourceGame string    `json:"sourceGame,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// PuzzleAttempt is one player's try at solving a puzzle
+type PuzzleAttempt struct {
+	PuzzleID uuid.UUID `json:"puzzleID"`
+	Username string    `json:"username"`
+	// Moves are the solver's moves as they were sent in, and Line is how the puzzle played out, with the replies
+	Moves  []string `json:"moves"`
+	Line   []string `json:"line"`
+	Solved bool     `json:"solved"`
+	// Problem says where an attempt that didn't solve the puzzle went wrong
+	Problem string    `json:"problem,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// puzzleNamespace makes puzzle IDs out of their positions, so the same position can't be stored as two puzzles
+var puzzleNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/adamehirsch/gotak/puzzles")
+
+// NewPuzzle checks that the player to move in a TPS position can force a road in exactly depth moves, and no fewer,
+// and works out a solution
+func NewPuzzle(tps string, depth int) (*Puzzle, error) {
+	if depth < 1 || depth > maxPuzzleDepth {
+		return nil, fmt.Errorf("depth must be a number from 1 to %v", maxPuzzleDepth)
+	}
+	start, err := GameFromTPS(tps)
+	if err != nil {
+		return nil, err
+	}
+	tg := start.perftCopy()
+	if tg.IsGameOver() {
+		return nil, errors.New("game is already over")
+	}
+	for quicker := 1; quicker < depth; quicker++ {
+		if tg.ForcedRoadWin(quicker) != nil {
+			return nil, fmt.Errorf("there's a road in %v", quicker)
+		}
+	}
+	if tg.ForcedRoadWin(depth) == nil {
+		return nil, fmt.Errorf("there's no road to force in %v", depth)
+	}
+
+	solution := []string{}
+	for moves := depth; !tg.GameOver; moves-- {
+		if tg, err = tg.playCopy(tg.ForcedRoadWin(moves)); err != nil {
+			return nil, err
+		}
+		solution = append(solution, tg.lastMovePTN())
+		if !tg.GameOver {
+			if tg, err = tg.playCopy(tg.puzzleDefense()); err != nil {
+				return nil, err
+			}
+			solution = append(solution, tg.lastMovePTN())
+		}
+	}
+
+	return &Puzzle{
+		PuzzleID: uuid.NewV5(puzzleNamespace, start.TPS()),
+		TPS:      start.TPS(),
+		ToMove:   start.toMove(),
+		Depth:    depth,
+		Solution: solution,
+		Created:  time.Now(),
+	}, nil
+}
+
+// lastMovePTN writes out the most recent move in the game's TurnHistory
+func (tg *TakGame) lastMovePTN() string {
+	ptn, _ := ActionPTN(tg.TurnHistory[len(tg.TurnHistory)-1])
+	return ptn
+}
+
+// puzzleDefense picks a reply for the player defending against a puzzle. They're lost whatever they do, so the
+// best they can do is not to hand over the road straight away.
+func (tg *TakGame) puzzleDefense() interface{} {
+	attacker := oppositeColor(tg.toMove())
+	moves := tg.LegalMoves()
+	for _, move := range moves {
+		next, err := tg.playCopy(move)
+		if err == nil && !next.GameOver && len(next.roadWinningMoves(attacker, true)) == 0 {
+			return move
+		}
+	}
+	for _, move := range moves {
+		if next, err := tg.playCopy(move); err == nil && !next.GameOver {
+			return move
+		}
+	}
+	return moves[0]
+}
+
+// Attempt plays a player's moves through a puzzle, answering each with the defender's reply, and says whether they
+// solve it. Moves that follow the solution are taken on trust; any other move has to leave the defender in tinue,
+// or finish the road there and then.
+func (p *Puzzle) Attempt(username string, moves []string) *PuzzleAttempt {
+	a := &PuzzleAttempt{PuzzleID: p.PuzzleID, Username: username, Moves: moves, Line: []string{}, Time: time.Now()}
+	start, err := GameFromTPS(p.TPS)
+	if err != nil {
+		a.Problem = fmt.Sprintf("problem setting up the puzzle: %v", err)
+		return a
+	}
+	tg := start.perftCopy()
+
+	for i, ply := range moves {
+		move, err := tg.ParsePTNMove(ply)
+		if err == nil {
+			tg, err = tg.playCopy(move)
+		}
+		if err != nil {
+			a.Problem = fmt.Sprintf("problem playing %v: %v", ply, err)
+			return a
+		}
+		a.Line = append(a.Line, tg.lastMovePTN())
+
+		switch remaining := p.Depth - i - 1; {
+		case tg.GameOver && tg.RoadWin && tg.GameWinner == p.ToMove:
+			a.Solved = true
+			return a
+		case tg.GameOver:
+			a.Problem = fmt.Sprintf("%v ends the game without a road for %v", ply, p.ToMove)
+			return a
+		case p.onSolution(a.Line):
+		case remaining == 0 || !tg.InTinue(remaining):
+			a.Problem = fmt.Sprintf("%v lets %v off the hook", ply, tg.toMove())
+			return a
+		}
+
+		// the defender sticks to the solution for as long as the solver does
+		var reply interface{}
+		if p.onSolution(a.Line) && len(p.Solution) > len(a.Line) {
+			reply, _ = tg.ParsePTNMove(p.Solution[len(a.Line)])
+		}
+		if reply == nil {
+			reply = tg.puzzleDefense()
+		}
+		if tg, err = tg.playCopy(reply); err != nil {
+			a.Problem = fmt.Sprintf("problem playing the defense: %v", err)
+			return a
+		}
+		a.Line = append(a.Line, tg.lastMovePTN())
+	}
+	a.Problem = "the road isn't finished yet"
+	return a
+}
+
+// onSolution is true if a line of play is the start of the puzzle's solution
+func (p *Puzzle) onSolution(line []string) bool {
+	if len(line) > len(p.Solution) {
+		return false
+	}
+	for i, ply := range line {
+		if ply != p.Solution[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MinePuzzles looks through the ends of finished games for puzzles: positions where the eventual winner could have
+// forced their road in from 2 to depth moves. Each game gives at most one puzzle, the longest it has.
+func MinePuzzles(games []*TakGame, depth int) []*Puzzle {
+	puzzles := []*Puzzle{}
+	for _, tg := range games {
+		if !tg.GameOver || !tg.RoadWin {
+			continue
+		}
+		// the winner made the last move, so they were to move an odd number of plies before the end
+		for d := depth; d >= 2; d-- {
+			position, err := tg.PositionAt(tg.PlyCount() - (2*d - 1))
+			if err != nil {
+				continue
+			}
+			if puzzle, err := NewPuzzle(position.TPS(), d); err == nil {
+				puzzle.SourceGame = tg.GameID.String()
+				puzzles = append(puzzles, puzzle)
+				break
+			}
+		}
+	}
+	return puzzles
+}
+
+// minePuzzles stores the puzzles found in the database's finished games, and says how many there were
+func (env *DBenv) minePuzzles(depth int) (int, error) {
+	games, err := env.db.FinishedGames()
+	if err != nil {
+		return 0, err
+	}
+	puzzles := MinePuzzles(games, depth)
+	for _, puzzle := range puzzles {
+		if err := env.db.StorePuzzle(puzzle); err != nil {
+			return 0, err
+		}
+	}
+	return len(puzzles), nil
+}
+
+// puzzlesCommand mines puzzles from the finished games in the database: gotak --dbfile gotak.db puzzles --depth 3
+type puzzlesCommand struct {
+	Depth int `long:"depth" default:"2" description:"longest road to look for, in the winner's moves"`
+}
+
+// Run finds the puzzles and stores them
+func (pc *puzzlesCommand) Run() error {
+	if opts.DBfile == "" {
+		return errors.New("need a --dbfile to find games in")
+	}
+	if pc.Depth < 2 || pc.Depth > maxPuzzleDepth {
+		return fmt.Errorf("depth must be a number from 2 to %v", maxPuzzleDepth)
+	}
+	sqliteDB, err := InitSQLiteDB(opts.DBfile)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	found, err := (&DBenv{sqliteDB}).minePuzzles(pc.Depth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("found %v puzzles\n", found)
+	return nil
+}
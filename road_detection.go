@@ -54,6 +54,16 @@ func (tg *TakGame) CoordsAreOccupied(x, y int) bool {
 	return false
 }
 
+// IsRoadPiece tells whether the stack at x, y can be part of a road for the given color: flats and capstones
+// count, but walls don't.
+func (tg *TakGame) IsRoadPiece(x, y int, color string) bool {
+	if !tg.CoordsAreOccupied(x, y) {
+		return false
+	}
+	top := tg.GameBoard[x][y].Pieces[0]
+	return top.Color == color && top.Orientation != Wall
+}
+
 // NearbyOccupiedCoords returns a series of occpupied y/x coordinates for
 // orthogonal positions around a given start point that don't exceed the board size.
 func (tg *TakGame) NearbyOccupiedCoords(x, y int, direction string) []Coords {
@@ -86,38 +96,25 @@ func (tg *TakGame) NearbyOccupiedCoords(x, y int, direction string) []Coords {
 	return coordsToCheck
 }
 
-// IsRoadWin looks for a path across the board by the player of a given color.
+// IsRoadWin looks for a path across the board by the player of a given color. It only records the path it finds in
+// WinningPath: deciding who has actually won is up to WhoWins.
 func (tg *TakGame) IsRoadWin(color string) bool {
 
 	for j := 0; j < tg.Size; j++ {
 		// fmt.Printf("WE %v j%v\n", color, j)
 
 		// check for WestEast roads, starting on the leftmost side of the board
-		if tg.CoordsAreOccupied(0, j) && tg.GameBoard[0][j].Pieces[0].Color == color {
+		if tg.IsRoadPiece(0, j, color) {
 			// Check for WestEast roads.
 			if foundAPath := tg.roadCheck(newSearchedSquare(0, j), WestEast, color, []Coords{}); foundAPath == true {
-				tg.RoadWin = true
-				if color == Black {
-					tg.BlackWinner = true
-				}
-				if color == White {
-					tg.WhiteWinner = true
-				}
 				return true
 			}
 		}
 		// fmt.Printf("NS %v j%v\n", color, j)
 
 		// check NorthSouth roads
-		if tg.CoordsAreOccupied(j, 0) && tg.GameBoard[j][0].Pieces[0].Color == color {
+		if tg.IsRoadPiece(j, 0, color) {
 			if foundAPath := tg.roadCheck(newSearchedSquare(j, 0), NorthSouth, color, []Coords{}); foundAPath == true {
-				tg.RoadWin = true
-				if color == Black {
-					tg.BlackWinner = true
-				}
-				if color == White {
-					tg.WhiteWinner = true
-				}
 				return true
 			}
 		}
@@ -126,6 +123,25 @@ func (tg *TakGame) IsRoadWin(color string) bool {
 	return false
 }
 
+// RoadWinner names the color that has made a road, or "" if nobody has. A single stack move can finish roads for
+// both players at once, and in that case the rules give the win to the player who moved.
+func (tg *TakGame) RoadWinner() string {
+	// the player who just moved is the one whose turn it isn't
+	mover, other := Black, White
+	if tg.IsBlackTurn {
+		mover, other = White, Black
+	}
+	// check the mover last, so that WinningPath shows their road if they have one
+	otherRoad := tg.IsRoadWin(other)
+	switch {
+	case tg.IsRoadWin(mover):
+		return mover
+	case otherRoad:
+		return other
+	}
+	return ""
+}
+
 func (tg *TakGame) roadCheck(s *Square, dir string, color string, pp []Coords) bool {
 
 	boardsize := len(tg.GameBoard)
@@ -149,8 +165,8 @@ func (tg *TakGame) roadCheck(s *Square, dir string, color string, pp []Coords) b
 	coordsNearby := tg.NearbyOccupiedCoords(s.x, s.y, dir)
 
 	for _, c := range coordsNearby {
-		// if there's a correctly colored piece on the board in an adjacent square that hasn't been seen...
-		if tg.CoordsAreOccupied(c.X, c.Y) && tg.GameBoard[c.X][c.Y].Pieces[0].Color == color && !s.squareSearched(c.X, c.Y) {
+		// if there's a correctly colored flat or capstone in an adjacent square that hasn't been seen...
+		if tg.IsRoadPiece(c.X, c.Y, color) && !s.squareSearched(c.X, c.Y) {
 			nextSquare := newSearchedSquare(c.X, c.Y)
 			nextSquare.parent = s
 			// let's get recursive all up in here. Keep drilling down until we get to the bottom of the board ...
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// GameRules holds the rule variants a game is played with. Zero values mean the standard rules for the board size,
+// so a game with no rules set plays exactly as it always has.
+type GameRules struct {
+	// HalfKomi is the flat-count bonus black gets for moving second, in half flats: 5 is a komi of 2.5
+	HalfKomi int `json:"halfKomi,omitempty"`
+	// Stones overrides the number of stones (flats and walls) each player gets, when it's set
+	Stones *int `json:"stones,omitempty"`
+	// Capstones overrides the number of capstones each player gets, when it's set: 0 plays without capstones
+	Capstones *int `json:"capstones,omitempty"`
+	// NoOpeningSwap drops the rule that each player's first placement is a flat of the opponent's color
+	NoOpeningSwap bool `json:"noOpeningSwap,omitempty"`
+	// CarryLimit caps the number of pieces a stack move can carry, below the usual limit of the board size
+	CarryLimit int `json:"carryLimit,omitempty"`
+	// Repetition is "draw" to end the game in a draw when a position comes up a third time, or "claim" to let
+	// either player claim one. Tak has no repetition rule of its own, so by default positions can repeat forever.
+	Repetition string `json:"repetition,omitempty"`
+}
+
+// the repetition rules a game can be played with
+const (
+	RepetitionDraws  string = "draw"
+	RepetitionClaims string = "claim"
+)
+
+// Validate checks that a set of rules makes sense for a given board size
+func (gr GameRules) Validate(size int) error {
+	switch {
+	case gr.HalfKomi < 0:
+		return errors.New("komi can't be negative")
+	case gr.Stones != nil && *gr.Stones < 1:
+		return errors.New("each player needs at least one stone")
+	case gr.Capstones != nil && *gr.Capstones < 0:
+		return errors.New("piece counts can't be negative")
+	case gr.CarryLimit < 0:
+		return errors.New("carry limit can't be negative")
+	case gr.CarryLimit > size:
+		return fmt.Errorf("carry limit %v is more than the board size %v", gr.CarryLimit, size)
+	case gr.Repetition != "" && gr.Repetition != RepetitionDraws && gr.Repetition != RepetitionClaims:
+		return fmt.Errorf("unknown repetition rule '%v': try draw or claim", gr.Repetition)
+	}
+	return nil
+}
+
+// Komi writes out the komi in flats, the way PTN's Komi tag does: "2.5"
+func (gr GameRules) Komi() string {
+	komi := strconv.Itoa(gr.HalfKomi / 2)
+	if gr.HalfKomi%2 == 1 {
+		komi += ".5"
+	}
+	return komi
+}
+
+// StoneLimit is the number of stones each player starts with
+func (tg *TakGame) StoneLimit() int {
+	if tg.Rules.Stones != nil {
+		return *tg.Rules.Stones
+	}
+	return PieceLimits[tg.Size]
+}
+
+// CapstoneLimit is the number of capstones each player starts with
+func (tg *TakGame) CapstoneLimit() int {
+	if tg.Rules.Capstones != nil {
+		return *tg.Rules.Capstones
+	}
+	return CapstoneLimits[tg.Size]
+}
+
+// CarryLimit is the most pieces a single stack move can carry
+func (tg *TakGame) CarryLimit() int {
+	if tg.Rules.CarryLimit > 0 && tg.Rules.CarryLimit < tg.Size {
+		return tg.Rules.CarryLimit
+	}
+	return tg.Size
+}
+
+// InOpeningSwap is true for the first two plies of a game played with the opening swap, when each player places
+// a flat of the opponent's color.
+func (tg *TakGame) InOpeningSwap() bool {
+	return !tg.Rules.NoOpeningSwap && tg.PlyCount() < 2
+}
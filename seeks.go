@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// SeekRandom is the color a seek asks for when either seat will do
+const SeekRandom = "random"
+
+// how long a seek stays open, by default and at most
+const (
+	defaultSeekLifetime = 30 * time.Minute
+	maxSeekLifetime     = 7 * 24 * time.Hour
+)
+
+// Seek is a player looking for a game: "5x5, 10+5, I play white, rated". Anyone can accept an open seek, and whoever
+// does gets the other seat in a new game. A seek with an Opponent is a challenge that only they can accept.
+type Seek struct {
+	SeekID uuid.UUID `json:"seekID"`
+	// Player is who posted the seek, and owns the game it turns into
+	Player string `json:"player"`
+	Size   int    `json:"size"`
+	// Color is the seat Player wants: "white", "black" or "random"
+	Color       string       `json:"color"`
+	Rated       bool         `json:"rated"`
+	IsPublic    bool         `json:"isPublic"`
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	Rules       GameRules    `json:"rules"`
+	// Opponent is the player being challenged, for a direct challenge
+	Opponent string    `json:"opponent,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
+
+// NewSeek sets up a seek for a player that stays open for the given time, or for defaultSeekLifetime if that's 0
+func NewSeek(player string, lifetime time.Duration) *Seek {
+	if lifetime == 0 {
+		lifetime = defaultSeekLifetime
+	}
+	now := clockNow()
+	return &Seek{SeekID: uuid.NewV4(), Player: player, Created: now, Expires: now.Add(lifetime)}
+}
+
+// Validate checks that a seek makes sense, filling in a random color if none was asked for
+func (s *Seek) Validate() error {
+	switch s.Color {
+	case "":
+		s.Color = SeekRandom
+	case White, Black, SeekRandom:
+	default:
+		return fmt.Errorf("unknown color '%v': try %v, %v or %v", s.Color, White, Black, SeekRandom)
+	}
+	if s.Size < 3 || s.Size > 8 {
+		return errors.New("board size must be in the range 3 to 8 squares")
+	}
+	if err := s.Rules.Validate(s.Size); err != nil {
+		return err
+	}
+	if s.TimeControl != nil {
+		if err := s.TimeControl.Validate(); err != nil {
+			return err
+		}
+	}
+	switch lifetime := s.Expires.Sub(s.Created); {
+	case lifetime <= 0:
+		return errors.New("a seek has to stay open for some time")
+	case lifetime > maxSeekLifetime:
+		return fmt.Errorf("a seek can stay open for %v at most", maxSeekLifetime)
+	}
+	if s.Opponent == s.Player {
+		return errors.New("can't challenge yourself")
+	}
+	return nil
+}
+
+// Expired is true once a seek is past its expiry time, and can't be accepted any more
+func (s *Seek) Expired() bool {
+	return !clockNow().Before(s.Expires)
+}
+
+// VisibleTo says whether a player gets to see a seek: open seeks are there for everyone, but a challenge is only
+// shown to the two players it's between
+func (s *Seek) VisibleTo(username string) bool {
+	return s.Opponent == "" || s.Player == username || s.Opponent == username
+}
+
+// StartGame sets up the game a seek asks for, with the seek's player and the player accepting it seated and ready
+// to go. White moves first, so the color a player asks for also decides whether they go first.
+func (s *Seek) StartGame(accepter string) (*TakGame, error) {
+	tg, err := MakeGame(s.Size)
+	if err != nil {
+		return nil, err
+	}
+	tg.IsBlackTurn = false
+	tg.Rules = s.Rules
+	tg.UpdateReserves()
+	if s.TimeControl != nil {
+		timeControl := *s.TimeControl
+		tg.TimeControl = &timeControl
+		tg.StartClock()
+	}
+
+	color := s.Color
+	if color == SeekRandom {
+		color = White
+		if rand.New(rand.NewSource(time.Now().UnixNano())).Intn(2) == 0 {
+			color = Black
+		}
+	}
+	tg.WhitePlayer, tg.BlackPlayer = s.Player, accepter
+	if color == Black {
+		tg.WhitePlayer, tg.BlackPlayer = accepter, s.Player
+	}
+
+	tg.GameOwner = s.Player
+	tg.IsPublic = s.IsPublic
+	tg.Rated = s.Rated
+	tg.HasStarted = true
+	tg.Created = time.Now()
+	return tg, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TakebackRequest is one player's request to undo the last ply or two, waiting on their opponent's answer
+type TakebackRequest struct {
+	// Player is the username of whoever asked for the takeback
+	Player string `json:"player"`
+	// Plies is how many plies to undo: 1 for the last move, 2 for the last full turn
+	Plies int       `json:"plies"`
+	Time  time.Time `json:"time"`
+}
+
+// RequestTakeback asks to undo the last few plies, which only happens once the opponent accepts. Asking for 0 plies
+// undoes whatever it takes to get back to the requester's own turn: their own last move if they've just moved,
+// or their last move and the opponent's reply if it's their turn now.
+func (tg *TakGame) RequestTakeback(username string, plies int) error {
+	color := tg.PlayerColor(username)
+	switch {
+	case color == "":
+		return errors.New("only the players in a game can ask for a takeback")
+	case tg.GameOver:
+		return errors.New("game is over: no takebacks")
+	case tg.PendingTakeback != nil && tg.PendingTakeback.Player != username:
+		return fmt.Errorf("%v has already asked for a takeback: accept or decline it first", tg.PendingTakeback.Player)
+	}
+
+	if plies == 0 {
+		plies = 2
+		if len(tg.TurnHistory) > 0 && tg.TurnHistory[len(tg.TurnHistory)-1].Color == color {
+			plies = 1
+		}
+	}
+	switch {
+	case plies < 1 || plies > 2:
+		return fmt.Errorf("can only take back 1 or 2 plies, not %v", plies)
+	case plies > len(tg.TurnHistory):
+		return fmt.Errorf("can't take back %v plies: only %v have been played", plies, len(tg.TurnHistory))
+	}
+
+	// asking again just replaces the earlier request
+	tg.PendingTakeback = &TakebackRequest{Player: username, Plies: plies, Time: time.Now()}
+	tg.recordEvent(GameEvent{Type: TakebackRequested, Player: username, Plies: plies})
+	return nil
+}
+
+// AnswerTakeback accepts or declines the pending takeback. Only the opponent of whoever asked for it can answer.
+func (tg *TakGame) AnswerTakeback(username string, accept bool) error {
+	request := tg.PendingTakeback
+	switch {
+	case request == nil:
+		return errors.New("no takeback has been asked for")
+	case tg.PlayerColor(username) == "" || username == request.Player:
+		return fmt.Errorf("only %v's opponent can answer their takeback request", request.Player)
+	}
+
+	tg.PendingTakeback = nil
+	if !accept {
+		tg.recordEvent(GameEvent{Type: TakebackDeclined, Player: username, Plies: request.Plies})
+		return nil
+	}
+
+	undone := append([]MoveRecord(nil), tg.TurnHistory[len(tg.TurnHistory)-request.Plies:]...)
+	if err := tg.undoPlies(request.Plies); err != nil {
+		return err
+	}
+	tg.recordEvent(GameEvent{Type: TakebackAccepted, Player: username, Plies: request.Plies, Undone: undone})
+	return nil
+}
+
+// undoPlies takes the last few plies off the game, rebuilding the board from what's left of the TurnHistory and
+// restarting the clock on the turn it's gone back to
+func (tg *TakGame) undoPlies(plies int) error {
+	past, err := tg.PositionAt(tg.PlyCount() - plies)
+	if err != nil {
+		return err
+	}
+	tg.GameBoard = past.GameBoard
+	tg.IsBlackTurn = past.IsBlackTurn
+	tg.TurnHistory = past.TurnHistory
+	tg.WinningPath = past.WinningPath
+	tg.UpdateReserves()
+	tg.restartTurn()
+	return nil
+}
+
+// lapseTakeback drops a pending takeback request once another move has been played over the top of it
+func (tg *TakGame) lapseTakeback() {
+	if tg.PendingTakeback != nil {
+		tg.recordEvent(GameEvent{Type: TakebackLapsed, Player: tg.PendingTakeback.Player, Plies: tg.PendingTakeback.Plies})
+		tg.PendingTakeback = nil
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TEI, the Tak Engine Interface, is the text protocol Tak engines use to talk to whatever's running them, borrowed
+// from chess's UCI. The engine is told "tei" and answers with its name and "teiok"; it's given a position with
+// "position startpos moves a1 b1 ..." or "position tps <TPS> moves ...", and "go" with the time left on the clocks;
+// and it answers "bestmove <PTN>".
+
+// teiGrace is how much longer than its thinking time an engine gets to answer, and how long it gets for everything
+// else it's asked
+const teiGrace = 5 * time.Second
+
+// defaultMoveTime is how long an engine gets to think about each move in a game with no clock
+const defaultMoveTime = 2 * time.Second
+
+// TEIEngine is a bot backed by an external engine binary that speaks TEI. Each move gets a fresh run of the engine,
+// so engines can be shared between games without keeping track of which one is in which position.
+type TEIEngine struct {
+	Command string
+	Args    []string
+	// MoveTime is how long the engine gets to think in a game with no clock; zero means defaultMoveTime
+	MoveTime time.Duration
+}
+
+// ChooseMove starts the engine, asks it for a move, and shuts it down again
+func (te *TEIEngine) ChooseMove(tg *TakGame) (interface{}, error) {
+	cmd := exec.Command(te.Command, te.Args...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("can't start engine %v: %v", te.Command, err)
+	}
+
+	session := newTEISession(in, out)
+	defer func() {
+		session.send("quit")
+		in.Close()
+		// give the engine a moment to quit on its own before killing it
+		timer := time.AfterFunc(time.Second, func() { cmd.Process.Kill() })
+		cmd.Wait()
+		timer.Stop()
+		session.drain()
+	}()
+
+	moveTime := te.MoveTime
+	if moveTime == 0 {
+		moveTime = defaultMoveTime
+	}
+	return session.bestMove(tg, moveTime)
+}
+
+// teiSession is a conversation with an engine: lines go in on one side, and are read back a line at a time from the
+// other, so that an engine that goes quiet can be given up on.
+type teiSession struct {
+	in    io.Writer
+	lines chan string
+}
+
+func newTEISession(in io.Writer, out io.Reader) *teiSession {
+	s := &teiSession{in: in, lines: make(chan string)}
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			s.lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(s.lines)
+	}()
+	return s
+}
+
+func (s *teiSession) send(format string, args ...interface{}) error {
+	_, err := fmt.Fprintf(s.in, format+"\n", args...)
+	return err
+}
+
+// waitFor reads lines from the engine until one starts with the given word, skipping any info it sends along the way
+func (s *teiSession) waitFor(word string, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				return "", fmt.Errorf("engine quit without saying %v", word)
+			}
+			if line == word || strings.HasPrefix(line, word+" ") {
+				return line, nil
+			}
+		case <-deadline:
+			return "", fmt.Errorf("engine didn't say %v within %v", word, timeout)
+		}
+	}
+}
+
+// drain throws away anything else the engine says, so the reader can finish once it's gone
+func (s *teiSession) drain() {
+	go func() {
+		for range s.lines {
+		}
+	}()
+}
+
+// bestMove takes the engine through a whole move: handshake, new game, position, and search
+func (s *teiSession) bestMove(tg *TakGame, moveTime time.Duration) (interface{}, error) {
+	s.send("tei")
+	if _, err := s.waitFor("teiok", teiGrace); err != nil {
+		return nil, err
+	}
+	if tg.Rules.HalfKomi > 0 {
+		s.send("setoption name HalfKomi value %v", tg.Rules.HalfKomi)
+	}
+	s.send("teinewgame %v", tg.Size)
+	s.send("isready")
+	if _, err := s.waitFor("readyok", teiGrace); err != nil {
+		return nil, err
+	}
+
+	position, err := teiPosition(tg)
+	if err != nil {
+		return nil, err
+	}
+	s.send(position)
+	goCommand, thinkingTime := teiGo(tg, moveTime)
+	s.send(goCommand)
+
+	line, err := s.waitFor("bestmove", thinkingTime+teiGrace)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.New("engine didn't say which move was best")
+	}
+	return tg.ParsePTNMove(fields[1])
+}
+
+// teiPosition describes the game to an engine as its starting position and the moves played since
+func teiPosition(tg *TakGame) (string, error) {
+	position := "position startpos"
+	switch {
+	case tg.InitialPosition != "":
+		position = "position tps " + tg.InitialPosition
+	case tg.blackMovedFirst():
+		// the standard starting position has white to move
+		start, _ := MakeGame(tg.Size)
+		start.IsBlackTurn = true
+		position = "position tps " + start.TPS()
+	}
+	if len(tg.TurnHistory) == 0 {
+		return position, nil
+	}
+	moves := []string{}
+	for _, record := range tg.TurnHistory {
+		ptn, err := ActionPTN(record)
+		if err != nil {
+			return "", err
+		}
+		moves = append(moves, ptn)
+	}
+	return position + " moves " + strings.Join(moves, " "), nil
+}
+
+// teiGo tells an engine to start thinking: with the time left on both clocks in a timed game, or with a fixed time
+// for the move otherwise. It also says how long the engine gets to think. Bots play while a player's request waits
+// on them, so even with a whole clock to spend, an engine only gets the slice of it gotak's own bots would take,
+// and never more than moveTime.
+func teiGo(tg *TakGame, moveTime time.Duration) (string, time.Duration) {
+	if tg.Clock != nil && tg.TimeControl != nil && tg.TimeControl.DaysPerMove == 0 {
+		c := tg.Clock
+		increment := tg.TimeControl.Increment * 1000
+		remaining := c.WhiteRemaining
+		if tg.IsBlackTurn {
+			remaining = c.BlackRemaining
+		}
+		thinkingTime := moveTime
+		if slice := time.Duration(remaining/clockFraction) * time.Millisecond; slice < thinkingTime {
+			thinkingTime = slice
+		}
+		return fmt.Sprintf("go wtime %v btime %v winc %v binc %v", c.WhiteRemaining, c.BlackRemaining, increment, increment), thinkingTime
+	}
+	return fmt.Sprintf("go movetime %v", millis(moveTime)), moveTime
+}
+
+// teiCommand runs one of gotak's own bots as a TEI engine on stdin and stdout: gotak tei --bot minimax
+type teiCommand struct {
+	Bot string `long:"bot" default:"minimax" description:"which of gotak's bots to play with"`
+}
+
+// Run serves TEI until it's told to quit
+func (tc *teiCommand) Run() error {
+	return tc.serve(os.Stdin, os.Stdout)
+}
+
+// serve answers TEI commands from in on out, playing moves chosen by the bot
+func (tc *teiCommand) serve(in io.Reader, out io.Writer) error {
+	bot, ok := Bots[tc.Bot]
+	if !ok {
+		return fmt.Errorf("no such bot '%v'", tc.Bot)
+	}
+	var (
+		size     = 5
+		halfKomi int
+		game     *TakGame
+	)
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "tei":
+			fmt.Fprintf(out, "id name gotak %v\nid author gotak\noption name HalfKomi type spin default 0 min 0 max 20\nteiok\n", tc.Bot)
+		case "isready":
+			fmt.Fprintln(out, "readyok")
+		case "setoption":
+			// setoption name HalfKomi value 4
+			if len(fields) == 5 && fields[2] == "HalfKomi" {
+				halfKomi, _ = strconv.Atoi(fields[4])
+			}
+		case "teinewgame":
+			if len(fields) > 1 {
+				size, _ = strconv.Atoi(fields[1])
+			}
+			game = nil
+		case "position":
+			var err error
+			if game, err = teiGame(fields[1:], size, halfKomi); err != nil {
+				fmt.Fprintf(out, "info string %v\n", err)
+			}
+		case "go":
+			if game == nil {
+				fmt.Fprintln(out, "info string no position to search")
+				continue
+			}
+			move, err := withBudget(bot, teiBudget(fields[1:], game.IsBlackTurn)).ChooseMove(game)
+			if err != nil {
+				fmt.Fprintf(out, "info string %v\n", err)
+				continue
+			}
+			ptn, _ := ActionPTN(move)
+			fmt.Fprintf(out, "bestmove %v\n", ptn)
+		case "quit":
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// teiGame sets up the game described by a TEI position command, after the word "position"
+func teiGame(args []string, size, halfKomi int) (*TakGame, error) {
+	var (
+		game *TakGame
+		err  error
+	)
+	switch {
+	case len(args) > 0 && args[0] == "startpos":
+		if game, err = MakeGame(size); err != nil {
+			return nil, err
+		}
+		game.IsBlackTurn = false
+		args = args[1:]
+	case len(args) > 3 && args[0] == "tps":
+		// a TPS is the board, the player to move, and the move number
+		if game, err = GameFromTPS(strings.Join(args[1:4], " ")); err != nil {
+			return nil, err
+		}
+		args = args[4:]
+	default:
+		return nil, fmt.Errorf("can't understand position %v", strings.Join(args, " "))
+	}
+	game.Rules.HalfKomi = halfKomi
+	game.WhitePlayer, game.BlackPlayer = White, Black
+
+	if len(args) > 0 && args[0] == "moves" {
+		for _, ply := range args[1:] {
+			move, err := game.ParsePTNMove(ply)
+			if err != nil {
+				return nil, err
+			}
+			if err := game.ApplyAction(move); err != nil {
+				return nil, fmt.Errorf("problem playing %v: %v", ply, err)
+			}
+		}
+	}
+	return game, nil
+}
+
+// teiBudget works out how long to think from the arguments to a TEI go command: the move time if there is one, or
+// a small slice of the time left on the clock
+func teiBudget(args []string, blackToMove bool) time.Duration {
+	clock := "wtime"
+	if blackToMove {
+		clock = "btime"
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		ms, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			continue
+		}
+		switch args[i] {
+		case "movetime":
+			return time.Duration(ms) * time.Millisecond
+		case clock:
+			return time.Duration(ms/clockFraction) * time.Millisecond
+		}
+	}
+	return defaultMoveTime
+}
+
+// withBudget gives one of gotak's own bots a different time budget, leaving the original as it was
+func withBudget(bot Bot, budget time.Duration) Bot {
+	switch b := bot.(type) {
+	case *MinimaxBot:
+		budgeted := *b
+		budgeted.Budget = budget
+		return &budgeted
+	case *MCTSBot:
+		budgeted := *b
+		budgeted.Budget = budget
+		return &budgeted
+	}
+	return bot
+}
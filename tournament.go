@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTournamentPlies ends a tournament game in an adjudicated draw if it's still going after this many plies
+const maxTournamentPlies = 300
+
+// tournamentCommand plays two bots against each other from the command line, for trying out changes to them:
+// gotak tournament --size 5 --games 20 --engine1 minimax:roadline=20 --engine2 minimax
+type tournamentCommand struct {
+	Size        int    `long:"size" default:"5" description:"size of the board"`
+	Games       int    `long:"games" default:"10" description:"number of games to play"`
+	Engine1     string `long:"engine1" default:"minimax" description:"first bot, with any settings to change: minimax:depth=2,roadline=20"`
+	Engine2     string `long:"engine2" default:"mcts" description:"second bot, with any settings to change: mcts:playouts=500"`
+	RandomPlies int    `long:"random-plies" default:"2" description:"random moves to open each game with, so the games aren't all the same"`
+	Seed        int64  `long:"seed" description:"seed for the random opening moves; 0 seeds from the clock"`
+	Out         string `long:"out" default:"." description:"directory to write each game's PTN to"`
+}
+
+// Run plays the tournament and prints the results
+func (tc *tournamentCommand) Run() error {
+	return tc.play(os.Stdout)
+}
+
+// play runs every game of the tournament, writing each one out as PTN as it finishes and reporting on out
+func (tc *tournamentCommand) play(out io.Writer) error {
+	if tc.Games < 1 {
+		return errors.New("a tournament needs at least one game")
+	}
+	engine1, err := configureBot(tc.Engine1)
+	if err != nil {
+		return err
+	}
+	engine2, err := configureBot(tc.Engine2)
+	if err != nil {
+		return err
+	}
+	seed := tc.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	result := &TournamentResult{Engine1: tc.Engine1, Engine2: tc.Engine2, Size: tc.Size}
+	for i := 0; i < tc.Games; i++ {
+		// the engines take turns at white
+		engine1White := i%2 == 0
+		white, black, whiteName, blackName := engine1, engine2, tc.Engine1, tc.Engine2
+		if !engine1White {
+			white, black, whiteName, blackName = engine2, engine1, tc.Engine2, tc.Engine1
+		}
+		tg, err := playTournamentGame(tc.Size, white, black, whiteName, blackName, tc.RandomPlies, r)
+		if err != nil {
+			return fmt.Errorf("problem in game %v: %v", i+1, err)
+		}
+
+		ptn, err := tg.PTN()
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(tc.Out, fmt.Sprintf("game%03d.ptn", i+1))
+		if err := ioutil.WriteFile(file, []byte(ptn), 0644); err != nil {
+			return err
+		}
+		result.Add(tg, engine1White)
+		fmt.Fprintf(out, "game %v: %v (white) vs %v (black): %v in %v plies\n", i+1, whiteName, blackName, tg.ResultCode(), tg.PlyCount())
+	}
+	fmt.Fprintln(out)
+	fmt.Fprint(out, result.Summary())
+	return nil
+}
+
+// playTournamentGame plays one game between two bots under the full rules, opening with a few random moves. Since
+// bots can shuffle back and forth forever, positions that come up three times are draws, and so are games that go
+// on for maxTournamentPlies.
+func playTournamentGame(size int, white, black Bot, whiteName, blackName string, randomPlies int, r *rand.Rand) (*TakGame, error) {
+	tg, err := MakeGame(size)
+	if err != nil {
+		return nil, err
+	}
+	tg.IsBlackTurn = false
+	tg.WhitePlayer, tg.BlackPlayer = whiteName, blackName
+	tg.Rules.Repetition = RepetitionDraws
+	tg.StartTime = time.Now()
+
+	for !tg.GameOver {
+		if tg.PlyCount() >= maxTournamentPlies {
+			tg.recordResult("", byMoveLimit)
+			tg.IsGameOver()
+			break
+		}
+		var move interface{}
+		if tg.PlyCount() < randomPlies {
+			moves := tg.LegalMoves()
+			move = moves[r.Intn(len(moves))]
+		} else {
+			bot := white
+			if tg.IsBlackTurn {
+				bot = black
+			}
+			if move, err = bot.ChooseMove(tg); err != nil {
+				return nil, err
+			}
+		}
+		if err := tg.ApplyAction(move); err != nil {
+			return nil, err
+		}
+	}
+	return tg, nil
+}
+
+// configureBot sets up a bot from a spec: the name of one of the Bots, on its own or followed by a colon and the
+// settings to change, like "minimax:depth=2,roadline=20". The bot in Bots is left as it was.
+func configureBot(spec string) (Bot, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	bot, ok := Bots[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("no such bot '%v'", parts[0])
+	}
+	if len(parts) == 1 {
+		return bot, nil
+	}
+
+	switch b := bot.(type) {
+	case *MinimaxBot:
+		configured := *b
+		weights := DefaultWeights
+		if b.Weights != nil {
+			weights = *b.Weights
+		}
+		configured.Weights = &weights
+		err := applySettings(parts[1], map[string]interface{}{
+			"depth":     &configured.MaxDepth,
+			"budget":    &configured.Budget,
+			"book":      &configured.Book,
+			"flat":      &weights.Flat,
+			"wall":      &weights.Wall,
+			"capstone":  &weights.Capstone,
+			"capcenter": &weights.CapCenter,
+			"hardflat":  &weights.HardFlat,
+			"captive":   &weights.Captive,
+			"roadline":  &weights.RoadLine,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &configured, nil
+	case *MCTSBot:
+		configured := *b
+		err := applySettings(parts[1], map[string]interface{}{
+			"playouts": &configured.Playouts,
+			"budget":   &configured.Budget,
+			"biased":   &configured.Biased,
+			"seed":     &configured.Seed,
+			"book":     &configured.Book,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &configured, nil
+	}
+	return nil, fmt.Errorf("bot '%v' has no settings to change", parts[0])
+}
+
+// applySettings sets each of a comma-separated list of name=value settings on the field it names
+func applySettings(settings string, fields map[string]interface{}) error {
+	for _, setting := range strings.Split(settings, ",") {
+		nameValue := strings.SplitN(setting, "=", 2)
+		if len(nameValue) != 2 {
+			return fmt.Errorf("can't understand setting '%v'", setting)
+		}
+		name, value := strings.ToLower(nameValue[0]), nameValue[1]
+		var err error
+		switch field := fields[name].(type) {
+		case *int:
+			*field, err = strconv.Atoi(value)
+		case *int64:
+			*field, err = strconv.ParseInt(value, 10, 64)
+		case *bool:
+			*field, err = strconv.ParseBool(value)
+		case *time.Duration:
+			*field, err = time.ParseDuration(value)
+		default:
+			return fmt.Errorf("no such setting '%v'", name)
+		}
+		if err != nil {
+			return fmt.Errorf("bad value for %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// TournamentResult adds up the games of a tournament between two engines
+type TournamentResult struct {
+	Engine1 string
+	Engine2 string
+	Size    int
+	Games   int
+	Plies   int
+	// Records are the wins for Engine1 and Engine2, in that order
+	Records [2]EngineRecord
+	Draws   int
+}
+
+// EngineRecord counts one engine's wins in a tournament, and how they came about
+type EngineRecord struct {
+	Wins     int
+	RoadWins int
+	FlatWins int
+}
+
+// Add counts a finished game
+func (tr *TournamentResult) Add(tg *TakGame, engine1White bool) {
+	tr.Games++
+	tr.Plies += tg.PlyCount()
+	if tg.GameWinner == "" {
+		tr.Draws++
+		return
+	}
+	winner := 0
+	if (tg.GameWinner == White) != engine1White {
+		winner = 1
+	}
+	record := &tr.Records[winner]
+	record.Wins++
+	switch {
+	case tg.RoadWin:
+		record.RoadWins++
+	case tg.FlatWin:
+		record.FlatWins++
+	}
+}
+
+// Score is Engine1's share of the points, counting a draw as half a win
+func (tr *TournamentResult) Score() float64 {
+	return (float64(tr.Records[0].Wins) + float64(tr.Draws)/2) / float64(tr.Games)
+}
+
+// EloDifference estimates how much stronger Engine1 is than Engine2 in Elo points, and the margin of error either
+// side of that at 95% confidence. A clean sweep would be infinitely many points, so scores are kept half a game
+// away from 0 and 1.
+func (tr *TournamentResult) EloDifference() (float64, float64) {
+	n := float64(tr.Games)
+	clamp := func(score float64) float64 {
+		return math.Min(math.Max(score, 0.5/n), 1-0.5/n)
+	}
+	elo := func(score float64) float64 {
+		return -400 * math.Log10(1/clamp(score)-1)
+	}
+
+	p := clamp(tr.Score())
+	// the spread of the points from each game around the average
+	variance := (float64(tr.Records[0].Wins)*(1-p)*(1-p) + float64(tr.Draws)*(0.5-p)*(0.5-p) + float64(tr.Records[1].Wins)*p*p) / n
+	margin := 1.96 * math.Sqrt(variance/n)
+	return elo(p), (elo(p+margin) - elo(p-margin)) / 2
+}
+
+// Summary writes up the results of the tournament for people to read
+func (tr *TournamentResult) Summary() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v vs %v: %v games on %vx%v\n", tr.Engine1, tr.Engine2, tr.Games, tr.Size, tr.Size)
+	for i, name := range []string{tr.Engine1, tr.Engine2} {
+		record := tr.Records[i]
+		fmt.Fprintf(&b, "%v: %v wins (%v road, %v flat, %v other), %.1f%% of games\n", name, record.Wins, record.RoadWins, record.FlatWins, record.Wins-record.RoadWins-record.FlatWins, 100*float64(record.Wins)/float64(tr.Games))
+	}
+	fmt.Fprintf(&b, "draws: %v\n", tr.Draws)
+	fmt.Fprintf(&b, "average game length: %.1f plies\n", float64(tr.Plies)/float64(tr.Games))
+	elo, margin := tr.EloDifference()
+	fmt.Fprintf(&b, "Elo difference: %+.0f ± %.0f\n", elo, margin)
+	return b.String()
+}
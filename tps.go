@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	tpsRegexp       = regexp.MustCompile(`^\s*\[?\s*(?:TPS\s+)?"?([^ "]+)\s+([12])\s+(\d+)"?\s*\]?\s*$`)
+	tpsSquareRegexp = regexp.MustCompile(`^(?:x([1-8]?)|([12]+)([SC]?))$`)
+)
+
+// TPS renders the game's current position in Tak Positional System notation, e.g. "x3/x,2,1/x3 1 2"
+func (tg *TakGame) TPS() string {
+	var b bytes.Buffer
+
+	// TPS rows run from the top of the board (the highest rank) down
+	for y := tg.Size - 1; y >= 0; y-- {
+		squares := []string{}
+		empties := 0
+		for x := 0; x < tg.Size; x++ {
+			pieces := tg.GameBoard[x][y].Pieces
+			if len(pieces) == 0 {
+				empties++
+				continue
+			}
+			if empties > 0 {
+				squares = append(squares, tpsEmpties(empties))
+				empties = 0
+			}
+			squares = append(squares, tpsStack(pieces))
+		}
+		if empties > 0 {
+			squares = append(squares, tpsEmpties(empties))
+		}
+		b.WriteString(strings.Join(squares, ","))
+		if y > 0 {
+			b.WriteString("/")
+		}
+	}
+
+	player := 1
+	if tg.IsBlackTurn {
+		player = 2
+	}
+	fmt.Fprintf(&b, " %v %v", player, tg.MoveNumber())
+	return b.String()
+}
+
+// tpsEmpties writes a run of empty squares
+func tpsEmpties(n int) string {
+	if n == 1 {
+		return "x"
+	}
+	return fmt.Sprintf("x%v", n)
+}
+
+// tpsStack writes a stack bottom-up, with the top piece's type (if it's not a flat) tacked on the end
+func tpsStack(pieces []Piece) string {
+	var b bytes.Buffer
+	for i := len(pieces) - 1; i >= 0; i-- {
+		if pieces[i].Color == Black {
+			b.WriteString("2")
+		} else {
+			b.WriteString("1")
+		}
+	}
+	switch pieces[0].Orientation {
+	case Wall:
+		b.WriteString("S")
+	case Capstone:
+		b.WriteString("C")
+	}
+	return b.String()
+}
+
+// GameFromTPS sets up a new TakGame at the position described by a TPS string.
+// The string may be bare, or wrapped up as a PTN tag: [TPS "x5/x5/x5/x5/x5 1 1"]
+func GameFromTPS(tps string) (*TakGame, error) {
+	parts := tpsRegexp.FindStringSubmatch(tps)
+	if parts == nil {
+		return nil, fmt.Errorf("could not parse TPS '%v': expected rows, player to move and move number", strings.TrimSpace(tps))
+	}
+	rows := strings.Split(parts[1], "/")
+
+	tg, err := MakeGame(len(rows))
+	if err != nil {
+		return nil, fmt.Errorf("TPS has %v rows: %v", len(rows), err)
+	}
+
+	for r, row := range rows {
+		y := tg.Size - 1 - r
+		x := 0
+		for _, square := range strings.Split(row, ",") {
+			s := tpsSquareRegexp.FindStringSubmatch(square)
+			if s == nil {
+				return nil, fmt.Errorf("could not parse TPS square '%v' in row %v", square, r+1)
+			}
+			if strings.HasPrefix(square, "x") {
+				empties := 1
+				if s[1] != "" {
+					empties, _ = strconv.Atoi(s[1])
+				}
+				x += empties
+				continue
+			}
+			if x >= tg.Size {
+				return nil, fmt.Errorf("TPS row %v describes more than %v squares", r+1, tg.Size)
+			}
+			// the stack is written bottom-up, but our stacks keep their top piece at [0]
+			pieces := make([]Piece, len(s[2]))
+			for i, c := range s[2] {
+				p := Piece{Color: White, Orientation: Flat}
+				if c == '2' {
+					p.Color = Black
+				}
+				pieces[len(s[2])-1-i] = p
+			}
+			switch s[3] {
+			case "S":
+				pieces[0].Orientation = Wall
+			case "C":
+				pieces[0].Orientation = Capstone
+			}
+			tg.GameBoard[x][y].Pieces = pieces
+			x++
+		}
+		if x != tg.Size {
+			return nil, fmt.Errorf("TPS row %v describes %v squares on a board %v wide", r+1, x, tg.Size)
+		}
+	}
+
+	moveNumber, _ := strconv.Atoi(parts[3])
+	if moveNumber < 1 {
+		return nil, errors.New("TPS move number must be at least 1")
+	}
+	tg.IsBlackTurn = parts[2] == "2"
+	// plies already played, counting the way TPS does with white moving first
+	tg.InitialPly = (moveNumber - 1) * 2
+	if tg.IsBlackTurn {
+		tg.InitialPly++
+	}
+	tg.InitialPosition = tg.TPS()
+	tg.UpdateReserves()
+	return tg, nil
+}
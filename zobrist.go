@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// zobristSeed fixes the zobrist keys, so that a position hashes the same way on every run and every server
+const zobristSeed uint64 = 0x676f74616b
+
+// zobristKey is the random key for one feature of a position. Rather than a table of keys, which would need a size
+// limit for stacks that can grow as tall as the pieces allow, each key comes straight out of a splitmix64 generator
+// seeded with zobristSeed and moved along to the feature's number.
+func zobristKey(feature uint64) uint64 {
+	z := zobristSeed + (feature+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// zobristBlackToMove is the key for black being the player to move
+var zobristBlackToMove = zobristKey(0)
+
+// zobristPieceKey is the key for a piece of a given color and orientation at a given height (counting up from the
+// bottom of the stack, so that dropping a piece on top only adds a key) on the square at x, y
+func zobristPieceKey(x, y, height int, p Piece) uint64 {
+	kind := 0
+	switch p.Orientation {
+	case Wall:
+		kind = 1
+	case Capstone:
+		kind = 2
+	}
+	if p.Color == Black {
+		kind += 3
+	}
+	square := uint64(x*8 + y)
+	return zobristKey(1 + (square*1024+uint64(height))*6 + uint64(kind))
+}
+
+// ZobristHash hashes the board and the player to move. Unlike a hash of the TPS, it leaves out the move number, so
+// the same position reached at different points in a game, or by a different order of moves, hashes the same.
+func (tg *TakGame) ZobristHash() uint64 {
+	var hash uint64
+	for x := range tg.GameBoard {
+		for y := range tg.GameBoard[x] {
+			pieces := tg.GameBoard[x][y].Pieces
+			for i, p := range pieces {
+				hash ^= zobristPieceKey(x, y, len(pieces)-1-i, p)
+			}
+		}
+	}
+	if tg.IsBlackTurn {
+		hash ^= zobristBlackToMove
+	}
+	return hash
+}
+
+// PositionHash is the game's ZobristHash as a string, the form it's recorded in each ply's MoveRecord and shown to
+// clients that want to spot transpositions.
+func (tg *TakGame) PositionHash() string {
+	return fmt.Sprintf("%016x", tg.ZobristHash())
+}
+
+// startingHash is the PositionHash of the position the game started from, before any of its TurnHistory
+func (tg *TakGame) startingHash() string {
+	if tg.InitialPosition != "" {
+		if start, err := GameFromTPS(tg.InitialPosition); err == nil {
+			return start.PositionHash()
+		}
+	}
+	// an empty board hashes to nothing but the player to move
+	var hash uint64
+	if tg.blackMovedFirst() {
+		hash = zobristBlackToMove
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// PositionHistory lists the hash of every position the game has been in, from its start to now. It's kept in the
+// TurnHistory, one hash to a MoveRecord, so a takeback takes its positions away with it.
+func (tg *TakGame) PositionHistory() []string {
+	history := []string{tg.startingHash()}
+	for _, record := range tg.TurnHistory {
+		history = append(history, record.PositionHash)
+	}
+	return history
+}
+
+// RepetitionCount is the number of times the current position has come up in the game, counting this time
+func (tg *TakGame) RepetitionCount() int {
+	current := tg.PositionHash()
+	count := 0
+	for _, hash := range tg.PositionHistory() {
+		if hash == current {
+			count++
+		}
+	}
+	return count
+}
+
+// repetitionLimit is how many times a position has to come up before it's a draw
+const repetitionLimit = 3
+
+// ClaimRepetition lets either player claim a draw once the current position has come up three times, in a game
+// played with repetition claims.
+func (tg *TakGame) ClaimRepetition(username string) error {
+	switch {
+	case tg.PlayerColor(username) == "":
+		return errors.New("only the players in a game can claim a draw")
+	case tg.GameOver:
+		return errors.New("game is already over")
+	case tg.Rules.Repetition != RepetitionClaims:
+		return errors.New("this game isn't played with repetition claims")
+	}
+	if count := tg.RepetitionCount(); count < repetitionLimit {
+		return fmt.Errorf("this position has come up %v times: it has to come up %v times to claim a draw", count, repetitionLimit)
+	}
+	tg.DrawOffer = ""
+	tg.PendingTakeback = nil
+	tg.recordResult("", byRepetition)
+	tg.recordEvent(GameEvent{Type: RepetitionClaimed, Player: username})
+	tg.IsGameOver()
+	return nil
+}